@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gomoltp/pkg/moltp"
+)
+
+// Status of a formula after a proof search
+const (
+	statusProved    = "proved"
+	statusNotProved = "not proved"
+	statusUnknown   = "unknown" // a limit stopped the search, it meets no expected status
+	statusError     = "error"
+)
+
+// batchEntry is a formula read from a batch file, Premises, Axioms, System, Agents, Syntax and Expected are optional
+type batchEntry struct {
+	Formula  string            `json:"formula"`
+	Premises []string          `json:"premises,omitempty"`
+	Axioms   []string          `json:"axioms,omitempty"`
+	System   string            `json:"system,omitempty"`
+	Agents   map[string]string `json:"agents,omitempty"`
+	Syntax   string            `json:"syntax,omitempty"`
+	Expected string            `json:"expected,omitempty"`
+}
+
+// readBatch reads the formulas to be proved.
+// A JSON array of entries is read as is, otherwise every line holds a formula
+// optionally preceded by its expected status, e.g. "not proved: \Box a \to a".
+// Empty lines and lines starting with # are skipped
+func readBatch(r io.Reader) ([]batchEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := []batchEntry{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &entries)
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range entries {
+			if e.Expected != "" && e.Expected != statusProved && e.Expected != statusNotProved && e.Expected != statusError {
+				return nil, fmt.Errorf("Entry %d: unknown status %s", i+1, e.Expected)
+			}
+		}
+		return entries, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e := batchEntry{Formula: line, Premises: premises, Axioms: axioms}
+		for _, s := range []string{statusNotProved, statusProved, statusError} {
+			if strings.HasPrefix(line, s+":") {
+				e.Expected = s
+				e.Formula = strings.TrimSpace(line[len(s)+1:])
+				break
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// status returns the status of a formula given the error returned by the prover
+func status(err error) string {
+	var lerr *moltp.LimitError
+	switch {
+	case err == nil:
+		return statusProved
+	case errors.As(err, &lerr):
+		return statusUnknown
+	case errors.Is(err, moltp.ErrNoSolution):
+		return statusNotProved
+	}
+	return statusError
+}
+
+// runBatch proves every formula of the batch file and returns false if some expected status was not met
+func runBatch(path string) (bool, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		r = f
+	}
+	entries, err := readBatch(r)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	failed := 0
+	total := 0.0
+	for i, e := range entries {
+		r, _ := solve(i, e)
+		total = total + r.Time
+
+		mismatch := e.Expected != "" && e.Expected != r.Status
+		if mismatch {
+			ok = false
+			failed = failed + 1
+		}
+		if output == outputJSON {
+			err := json.NewEncoder(os.Stdout).Encode(r)
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+		check := "ok"
+		if mismatch {
+			check = fmt.Sprintf("FAIL expected %s", e.Expected)
+		}
+		fmt.Printf("%d\t%s\t%s\t%.6fs\t%s\t%s\n", i+1, r.System, r.Status, r.Time, check, e.Formula)
+		if r.Status == statusError {
+			fmt.Printf("\t%s\n", r.Error)
+		}
+		if r.ParseError != nil {
+			fmt.Printf("\t%s\n", strings.Replace(r.ParseError.Caret(), "\n", "\n\t", 1))
+		}
+	}
+	if output == outputJSON {
+		return ok, nil
+	}
+	fmt.Printf("%d formulas, %d failed, %.6fs\n", len(entries), failed, total)
+	return ok, nil
+}
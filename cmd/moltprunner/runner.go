@@ -1,30 +1,195 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gomoltp/pkg/moltp"
 )
 
+// Output formats
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// formulasFlag collects the formulas given with a repeated flag
+type formulasFlag []string
+
+func (f *formulasFlag) String() string {
+	return strings.Join(*f, "; ")
+}
+
+func (f *formulasFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// agentsFlag collects the modal system of each agent given as agent=system with a repeated flag
+type agentsFlag map[string]string
+
+func (f agentsFlag) String() string {
+	out := []string{}
+	for a, s := range f {
+		out = append(out, fmt.Sprintf("%s=%s", a, s))
+	}
+	return strings.Join(out, ", ")
+}
+
+func (f agentsFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("%s is not in the form agent=system", v)
+	}
+	f[kv[0]] = kv[1]
+	return nil
+}
+
+// result of a proof search as printed by the json output format
+type result struct {
+	Formula      string                    `json:"formula"`
+	Premises     []string                  `json:"premises,omitempty"`
+	Axioms       []string                  `json:"axioms,omitempty"`
+	Parsed       string                    `json:"parsed,omitempty"`
+	System       string                    `json:"system"`
+	Agents       map[string]string         `json:"agents,omitempty"`
+	Status       string                    `json:"status"`
+	SZS          string                    `json:"szs"`
+	Expected     string                    `json:"expected,omitempty"`
+	Error        string                    `json:"error,omitempty"`
+	ParseError   *moltp.ParseError         `json:"parse_error,omitempty"`
+	Time         float64                   `json:"time"` // seconds
+	Proof        *map[int]moltp.RawSequent `json:"proof,omitempty"`
+	Countermodel *moltp.Countermodel       `json:"countermodel,omitempty"`
+}
+
 var (
-	debugOn bool
-	formula string
+	debugOn  bool
+	formula  string
+	system   string
+	timeout  time.Duration
+	steps    int
+	native   bool
+	syntax   string
+	form     string
+	batch    string
+	tptp     string
+	output   string
+	premises formulasFlag
+	axioms   formulasFlag
+	agents   = agentsFlag{}
 )
 
 func init() {
 	flag.StringVar(&formula, "f", "\\Box ( a \\to b ) \\to  ( \\Box a \\to \\Box b )", "Formula to be solved.")
 	flag.BoolVar(&debugOn, "v", false, "Swith for log printing")
+	flag.StringVar(&system, "s", moltp.SystemD, fmt.Sprintf("Modal system, one of %v", moltp.Systems()))
+	flag.DurationVar(&timeout, "t", 0, "Maximum search time, e.g. 10s. 0 means no limit.")
+	flag.IntVar(&steps, "steps", 0, "Maximum number of rule applications. 0 means no limit.")
+	flag.BoolVar(&native, "n", false, "Keep the connectives of the formula and use their own rules.")
+	flag.StringVar(&syntax, "syntax", moltp.SyntaxAuto, "Syntax of the formulas, tex, ascii or unicode. Guessed for each formula when empty.")
+	flag.StringVar(&form, "nf", moltp.NormalFormNone, "Normal form of the formulas before the search, nnf, cnf, mcnf or mcnf-renamed. Empty keeps the formulas as they are.")
+	flag.StringVar(&batch, "b", "", "File holding the formulas to be solved, one per line, - reads from stdin.")
+	flag.StringVar(&tptp, "tptp", "", "TPTP or QMLTP problem file, its SZS status is printed.")
+	flag.StringVar(&output, "o", outputText, "Output format, text or json.")
+	flag.Var(&premises, "p", "Premise holding in the root world, repeat it for each premise.")
+	flag.Var(&axioms, "a", "Axiom holding in every world, repeat it for each axiom.")
+	flag.Var(agents, "m", "Modal system of an agent of the indexed modalities, e.g. a=S5, repeat it for each agent.")
+}
+
+// solve proves a formula, the sequents of the solution or of the partial result are returned too
+func solve(oid int, e batchEntry) (*result, []*moltp.Sequent) {
+	s := e.System
+	if s == "" {
+		s = system
+	}
+	sx := e.Syntax
+	if sx == "" {
+		sx = syntax
+	}
+	ag := e.Agents
+	if ag == nil {
+		ag = agents
+	}
+	prover := moltp.Prover{Debug: debugOn, System: s, Agents: ag, Timeout: timeout, MaxSteps: steps, Native: native, Syntax: sx, NormalForm: form}
+	for i, h := range e.Premises {
+		prover.Premises = append(prover.Premises, &moltp.RawFormula{OID: i + 1, Formula: h})
+	}
+	for i, a := range e.Axioms {
+		prover.Axioms = append(prover.Axioms, &moltp.RawFormula{OID: len(e.Premises) + i + 1, Formula: a})
+	}
+	rf := &moltp.RawFormula{OID: oid, Formula: e.Formula}
+	r := &result{Formula: e.Formula, Premises: e.Premises, Axioms: e.Axioms, System: s, Agents: ag, Expected: e.Expected}
+
+	start := time.Now()
+	solution, model, err := prover.ProveOrRefute(rf)
+	r.Time = time.Since(start).Seconds()
+
+	r.Status = status(err)
+	r.SZS = szsStatus(err, model)
+	r.Countermodel = model
+	if err != nil {
+		r.Error = err.Error()
+		errors.As(err, &r.ParseError)
+	}
+	if parsed, err := moltp.Parse(e.Formula, sx); err == nil {
+		// with -n the prover keeps the connectives, so the formula is printed as it was parsed
+		if !native {
+			parsed = parsed.Reduce()
+		}
+		r.Parsed = parsed.String()
+	}
+	if len(solution) > 0 {
+		rawSolution, err := moltp.EncodeSequentSlice(solution)
+		if err == nil {
+			r.Proof = rawSolution
+		}
+	}
+	return r, solution
 }
 
 func main() {
 	flag.Parse()
-	rf := &moltp.RawFormula{OID: 0, Formula: formula}
-	prover := moltp.Prover{Debug: debugOn}
-	solution, err := prover.Prove(rf)
-	if err != nil {
-		log.Println(err)
+	if output != outputText && output != outputJSON {
+		log.Fatalf("Unknown output format %s", output)
+	}
+	if tptp != "" {
+		err := runTPTP(tptp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if batch != "" {
+		ok, err := runBatch(batch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	r, solution := solve(0, batchEntry{Formula: formula, Premises: premises, Axioms: axioms})
+	if output == outputJSON {
+		err := json.NewEncoder(os.Stdout).Encode(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if r.ParseError != nil {
+		log.Fatalf("%s\n%s", r.Error, r.ParseError.Caret())
+	}
+	if r.Status != statusProved {
+		log.Println(r.Error)
 		fmt.Println("Partial result:")
 	} else {
 		fmt.Println("Solution found:")
@@ -32,4 +197,8 @@ func main() {
 	for _, s := range solution {
 		fmt.Printf("\t%s\n", s)
 	}
+	if r.Countermodel != nil {
+		fmt.Println("Countermodel:")
+		fmt.Printf("\t%s\n", r.Countermodel)
+	}
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gomoltp/pkg/moltp"
+)
+
+// SZS status of a TPTP problem after a proof search
+const (
+	szsTheorem            = "Theorem"
+	szsCounterSatisfiable = "CounterSatisfiable"
+	szsTimeout            = "Timeout"
+	szsResourceOut        = "ResourceOut"
+	szsGaveUp             = "GaveUp"
+	szsInappropriate      = "Inappropriate"
+	szsError              = "Error"
+)
+
+// szsStatus returns the SZS status of a formula given the error returned by the prover and the countermodel found
+func szsStatus(err error, model *moltp.Countermodel) string {
+	var lerr *moltp.LimitError
+	switch {
+	case err == nil:
+		return szsTheorem
+	case model != nil:
+		return szsCounterSatisfiable
+	case errors.Is(err, context.DeadlineExceeded):
+		return szsTimeout
+	case errors.As(err, &lerr):
+		return szsResourceOut
+	case errors.Is(err, moltp.ErrNoSolution):
+		return szsGaveUp
+	}
+	return szsError
+}
+
+// runTPTP proves the conjecture of a TPTP or QMLTP problem and prints its SZS status.
+// Included files are read from the TPTP environment variable, or from the folder of the problem.
+// The system given by the logic specification of the problem takes precedence over -s
+func runTPTP(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dir := os.Getenv("TPTP")
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	p, err := moltp.ReadTPTP(f, dir)
+	if err != nil {
+		return printSZS(name, &result{Formula: path, Status: statusError, SZS: szsError, Error: err.Error()})
+	}
+	e := batchEntry{Formula: p.Conjecture.Formula, System: p.System, Syntax: moltp.SyntaxTeX}
+	for _, h := range p.Premises {
+		e.Premises = append(e.Premises, h.Formula)
+	}
+	// The prover uses constant domains and rigid constants
+	unsupported := []string{}
+	if p.Quantification != "" && p.Quantification != "constant" {
+		unsupported = append(unsupported, fmt.Sprintf("%s quantification", p.Quantification))
+	}
+	if p.Constants != "" && p.Constants != "rigid" {
+		unsupported = append(unsupported, fmt.Sprintf("%s constants", p.Constants))
+	}
+	if len(unsupported) > 0 {
+		return printSZS(name, &result{Formula: e.Formula, Premises: e.Premises, System: p.System, Status: statusError, SZS: szsInappropriate,
+			Error: fmt.Sprintf("%s not supported", strings.Join(unsupported, " and "))})
+	}
+	r, _ := solve(0, e)
+	return printSZS(name, r)
+}
+
+func printSZS(name string, r *result) error {
+	if output == outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(r)
+	}
+	fmt.Printf("%% SZS status %s for %s\n", r.SZS, name)
+	if r.Error != "" && r.SZS != szsTheorem {
+		fmt.Printf("%% %s\n", r.Error)
+	}
+	return nil
+}
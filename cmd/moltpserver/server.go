@@ -11,18 +11,48 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gomoltp/pkg/moltp"
 )
 
 type (
 	htmlData struct {
-		Static string
+		Static  string
+		Systems []systemOption
 	}
 
+	systemOption struct {
+		Name  string
+		Frame *moltp.Frame
+	}
+
+	// proofRequest is the body of a /prover request, System names a modal system
+	// and Frame selects the relation properties, Frame takes precedence over System
+	// Premises hold in the root world and Axioms in every world
+	// Agents names the modal system of the agents of the indexed modalities
+	// NormalForm is the normal form the formulas are turned into before the search
+	proofRequest struct {
+		moltp.RawFormula
+		Premises   []string          `json:"premises,omitempty"`
+		Axioms     []string          `json:"axioms,omitempty"`
+		System     string            `json:"system,omitempty"`
+		Frame      *moltp.Frame      `json:"frame,omitempty"`
+		Agents     map[string]string `json:"agents,omitempty"`
+		Native     bool              `json:"native,omitempty"`
+		Syntax     string            `json:"syntax,omitempty"`
+		NormalForm string            `json:"normal_form,omitempty"`
+	}
+
+	// infomessage holds the reason of a failure, ParseError is set when the formula of Field,
+	// or its Index-th premise or axiom, cannot be read
 	infomessage struct {
 		Info          string                    `json:"info"`
+		ParseError    *moltp.ParseError         `json:"parse_error,omitempty"`
+		Field         string                    `json:"field,omitempty"`
+		Index         int                       `json:"index,omitempty"`
 		PartialResult *map[int]moltp.RawSequent `json:"result"`
+		Countermodel  *moltp.Countermodel       `json:"countermodel,omitempty"`
 	}
 )
 
@@ -32,6 +62,9 @@ var (
 	templatesFolder string
 	debugOn         bool
 	port            int
+	timeout         time.Duration
+	maxSteps        int
+	maxSize         int
 )
 
 func init() {
@@ -39,6 +72,9 @@ func init() {
 	flag.StringVar(&templatesFolder, "templates", "/var/www/html/templates", "Path to folder holding html pages templates files.")
 	flag.BoolVar(&debugOn, "v", false, "Swith for log printing.")
 	flag.IntVar(&port, "port", 4000, "Http server port.")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "Maximum search time for a single request.")
+	flag.IntVar(&maxSteps, "steps", 100000, "Maximum number of rule applications for a single request.")
+	flag.IntVar(&maxSize, "size", 2000000, "Maximum number of formula nodes in the sequents of a single request.")
 }
 
 func fixFolderPath(p string) string {
@@ -73,7 +109,14 @@ func doInit() {
 }
 
 func index(w http.ResponseWriter, r *http.Request) {
-	err := indexTemplate.ExecuteTemplate(w, "base", htmlData{Static: staticFolder})
+	data := htmlData{Static: staticFolder}
+	for _, name := range moltp.Systems() {
+		f, err := moltp.SystemFrame(name)
+		if err == nil {
+			data.Systems = append(data.Systems, systemOption{Name: name, Frame: f})
+		}
+	}
+	err := indexTemplate.ExecuteTemplate(w, "base", data)
 	if err != nil {
 		log.Print(err)
 		http.NotFoundHandler()
@@ -99,21 +142,69 @@ func proofHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s\n", body)
 	log.Println("*************************************")
 
-	rf := &moltp.RawFormula{}
-	err = json.Unmarshal(body, rf)
-	if err != nil || len(rf.Formula) < 2 {
+	req := &proofRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil || len(req.Formula) < 2 {
 		log.Println("bad formula", err)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(infomessage{Info: "Bad formula"})
 		return
 	}
+	if req.Frame == nil && req.System != "" {
+		_, err = moltp.SystemFrame(req.System)
+		if err != nil {
+			log.Println("bad system", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(infomessage{Info: fmt.Sprintf("Bad system: %s", err)})
+			return
+		}
+	}
+	for a, name := range req.Agents {
+		_, err = moltp.SystemFrame(name)
+		if err != nil {
+			log.Println("bad agent system", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(infomessage{Info: fmt.Sprintf("Bad system of agent %s: %s", a, err)})
+			return
+		}
+	}
+	switch req.Syntax {
+	case moltp.SyntaxAuto, moltp.SyntaxTeX, moltp.SyntaxASCII, moltp.SyntaxUnicode:
+	default:
+		log.Println("bad syntax", req.Syntax)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(infomessage{Info: fmt.Sprintf("Bad syntax: %s", req.Syntax)})
+		return
+	}
+	switch req.NormalForm {
+	case moltp.NormalFormNone, moltp.NormalFormNNF, moltp.NormalFormCNF, moltp.NormalFormModalCNF, moltp.NormalFormRenamed:
+	default:
+		log.Println("bad normal form", req.NormalForm)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(infomessage{Info: fmt.Sprintf("Bad normal form: %s", req.NormalForm)})
+		return
+	}
+	if info := parseRequest(req); info != nil {
+		log.Println("bad formula", info.Info)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(info)
+		return
+	}
+	rf := &req.RawFormula
 
-	prover := moltp.Prover{Debug: debugOn}
-	solution, err := prover.Prove(rf)
+	// the limits bound the memory of a request, the timeout alone lets a fast search fill it
+	prover := moltp.Prover{Debug: debugOn, Timeout: timeout, MaxSteps: maxSteps, MaxSize: maxSize, System: req.System, Frame: req.Frame, Agents: req.Agents, Native: req.Native, Syntax: req.Syntax, NormalForm: req.NormalForm}
+	for i, h := range req.Premises {
+		prover.Premises = append(prover.Premises, &moltp.RawFormula{OID: i + 1, Formula: h})
+	}
+	for i, a := range req.Axioms {
+		prover.Axioms = append(prover.Axioms, &moltp.RawFormula{OID: len(req.Premises) + i + 1, Formula: a})
+	}
+	solution, model, err := prover.ProveOrRefuteContext(r.Context(), rf)
 	if err != nil {
 		log.Println("error solving", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		info := infomessage{Info: fmt.Sprintf("error solving: %s", err)}
+		info := infomessage{Info: fmt.Sprintf("error solving: %s", err), Countermodel: model}
 		rawSolution, err := moltp.EncodeSequentSlice(solution)
 		if err == nil {
 			info.PartialResult = rawSolution
@@ -143,6 +234,33 @@ func proofHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("*************************************")
 }
 
+// parseRequest reads the formula, the premises and the axioms of the request and
+// returns the error of the first one which cannot be read
+func parseRequest(req *proofRequest) *infomessage {
+	fields := []struct {
+		name     string
+		formulas []string
+	}{
+		{"formula", []string{req.Formula}},
+		{"premises", req.Premises},
+		{"axioms", req.Axioms},
+	}
+	for _, field := range fields {
+		for i, f := range field.formulas {
+			_, err := moltp.Parse(f, req.Syntax)
+			if err == nil {
+				continue
+			}
+			info := &infomessage{Info: fmt.Sprintf("Bad %s: %s", field.name, err), Field: field.name, Index: i}
+			if perr, ok := err.(*moltp.ParseError); ok {
+				info.ParseError = perr
+			}
+			return info
+		}
+	}
+	return nil
+}
+
 func main() {
 	doInit()
 
@@ -42,10 +42,109 @@ function fillSolution(data) {
   }
 }
 
+function fillCountermodel(model) {
+  let where = document.querySelector('#countermodel')
+  where.innerHTML = ''
+  if (model == undefined || model == null) {
+    return
+  }
+  document.querySelector('#cmtitle').innerText = "Countermodel"
+  model["worlds"].forEach(function(w) {
+    li = document.createElement('li')
+    where.appendChild(li)
+    let root = (w == model["root"]) ? " (root)" : ""
+    let to = model["relation"][w].join(", ")
+    let val = model["valuation"][w].join(", ")
+    let agents = ""
+    Object.keys(model["agents"] || {}).sort().forEach(function(a) {
+      agents += String(`, R_${a} = {${model["agents"][a][w].join(", ")}}`)
+    })
+    li.innerText = String(`${w}${root}: R = {${to}}${agents}, true = {${val}}`)
+  })
+}
+
+const properties = ['serial', 'reflexive', 'symmetric', 'transitive', 'euclidean']
+
+function selectSystem() {
+  let option = document.querySelector('#system').selectedOptions[0]
+  properties.forEach(function(p) {
+    document.querySelector(String(`#relation input[name=${p}]`)).checked = (option.dataset[p] == "true")
+  })
+}
+
+function readFrame() {
+  var frame = {}
+  properties.forEach(function(p) {
+    frame[p] = document.querySelector(String(`#relation input[name=${p}]`)).checked
+  })
+  let option = document.querySelector('#system').selectedOptions[0]
+  frame['includes'] = option.dataset['includes'].split(' ').filter(function(a) {
+    return a != ''
+  })
+  return frame
+}
+
+function readLines(id) {
+  return document.querySelector(String(`#${id}`)).value.split('\n').filter(function(l) {
+    return l.trim() != ''
+  })
+}
+
+// lineStart returns the position of the index-th non empty line of a textarea
+function lineStart(text, index) {
+  var start = 0
+  let lines = text.split('\n')
+  for (var i = 0; i < lines.length; i++) {
+    if (lines[i].trim() != '') {
+      if (index == 0) {
+        break
+      }
+      index--
+    }
+    start += lines[i].length + 1
+  }
+  return start
+}
+
+// showParseError selects the characters of the input which could not be read
+function showParseError(data) {
+  let ids = {'formula': 'f1', 'premises': 'premises', 'axioms': 'axioms'}
+  let input = document.querySelector(String(`#${ids[data["field"]]}`))
+  let e = data["parse_error"]
+  var start = 0
+  if (input.tagName == 'TEXTAREA') {
+    start = lineStart(input.value, data["index"] || 0)
+  }
+  let end = Math.max(e["end"], e["offset"] + 1)
+  input.classList.add('parseerror')
+  input.focus()
+  input.setSelectionRange(start + e["offset"], start + end)
+}
+
+function clearParseErrors() {
+  document.querySelectorAll('.parseerror').forEach(function(input) {
+    input.classList.remove('parseerror')
+  })
+}
+
+function readAgents() {
+  var agents = {}
+  document.querySelector('#agents').value.split(',').forEach(function(a) {
+    let kv = a.split('=')
+    if (kv.length == 2 && kv[0].trim() != '') {
+      agents[kv[0].trim()] = kv[1].trim()
+    }
+  })
+  return agents
+}
+
 function prove(){
-  var data = {'oid':0, 'formula':document.querySelector("#f1").value}
+  var data = {'oid':0, 'formula':document.querySelector("#f1").value, 'frame':readFrame(), 'premises':readLines('premises'), 'axioms':readLines('axioms'), 'agents':readAgents(), 'native':document.querySelector('#native').checked, 'syntax':document.querySelector('#syntax').value, 'normal_form':document.querySelector('#normalform').value}
   solution.innerHTML = ''
   document.querySelector('#soltitle').innerText = "Solution"
+  document.querySelector('#cmtitle').innerText = ""
+  document.querySelector('#countermodel').innerHTML = ''
+  clearParseErrors()
 
   return fetch("/prover", {
     method: "POST",
@@ -58,10 +157,14 @@ function prove(){
     if (response.status != 200) {
       response.json().then(function(data){
         alert(String(`${data["info"]}`))
+        if (response.status == 400 && data["parse_error"] != undefined) {
+          showParseError(data)
+        }
         if (response.status == 500) {
           if (data != null && data != "null")  {
             document.querySelector('#soltitle').innerText = "Partial result"
             fillSolution(data["result"])
+            fillCountermodel(data["countermodel"])
           }
         }
       })
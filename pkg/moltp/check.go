@@ -0,0 +1,931 @@
+package moltp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type (
+	proofJSON struct {
+		Root     string          `json:"root"`
+		Goal     string          `json:"goal,omitempty"`
+		Settings *ProofSettings  `json:"settings,omitempty"`
+		Steps    []proofStepJSON `json:"steps"`
+	}
+
+	proofStepJSON struct {
+		ID            string            `json:"id"`
+		Rule          string            `json:"rule,omitempty"`
+		Premises      []string          `json:"premises,omitempty"`
+		Principal     string            `json:"principal,omitempty"`
+		Complement    string            `json:"complement,omitempty"`
+		Substitution  map[string]string `json:"substitution,omitempty"`
+		Worlds        map[string]string `json:"worlds,omitempty"`
+		Justification []string          `json:"just,omitempty"`
+		Left          []*formula        `json:"left"`
+		Right         []*formula        `json:"right"`
+	}
+
+	// reductionShape is the shape of a rule rewriting a connective: the principal is the last formula
+	// on the left, or the first one on the right, and it is replaced by some of its operands on each side
+	reductionShape struct {
+		left    bool
+		op      string
+		toLeft  []int
+		toRight []int
+	}
+)
+
+func (e *ProofError) Error() string {
+	return fmt.Sprintf("Bad step %s: %s", e.Step, e.Reason)
+}
+
+// MarshalJSON encodes the proof, premises are referenced by their IDs
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	out := proofJSON{Steps: []proofStepJSON{}, Settings: p.Settings}
+	if p.Root != nil {
+		out.Root = p.Root.ID
+	}
+	if p.Goal != nil {
+		out.Goal = p.Goal.ID
+	}
+	for _, s := range p.Steps {
+		k := proofStepJSON{
+			ID:            s.ID,
+			Rule:          s.Rule,
+			Principal:     s.Principal,
+			Complement:    s.Complement,
+			Substitution:  s.Substitution,
+			Worlds:        s.Worlds,
+			Justification: s.Sequent.Justification,
+			Left:          s.Sequent.Left,
+			Right:         s.Sequent.Right,
+		}
+		for _, m := range s.Premises {
+			k.Premises = append(k.Premises, m.ID)
+		}
+		out.Steps = append(out.Steps, k)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a proof encoded by MarshalJSON
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	in := proofJSON{}
+	err := json.Unmarshal(data, &in)
+	if err != nil {
+		return err
+	}
+	steps := make(map[string]*ProofStep)
+	p.Steps = []*ProofStep{}
+	for _, k := range in.Steps {
+		if _, ok := steps[k.ID]; ok {
+			return fmt.Errorf("Duplicated step %s", k.ID)
+		}
+		s := &ProofStep{
+			ID:           k.ID,
+			Rule:         k.Rule,
+			Premises:     []*ProofStep{},
+			Principal:    k.Principal,
+			Complement:   k.Complement,
+			Substitution: k.Substitution,
+			Worlds:       k.Worlds,
+			Sequent:      &Sequent{Name: k.ID, Justification: k.Justification, Left: k.Left, Right: k.Right},
+		}
+		if s.Sequent.Left == nil {
+			s.Sequent.Left = []*formula{}
+		}
+		if s.Sequent.Right == nil {
+			s.Sequent.Right = []*formula{}
+		}
+		for _, m := range k.Premises {
+			premise, ok := steps[m]
+			if !ok {
+				return fmt.Errorf("Step %s uses %s before it is derived", k.ID, m)
+			}
+			s.Premises = append(s.Premises, premise)
+		}
+		steps[k.ID] = s
+		p.Steps = append(p.Steps, s)
+	}
+	p.Root = steps[in.Root]
+	p.Settings = in.Settings
+	p.Goal = nil
+	if in.Goal != "" {
+		goal, ok := steps[in.Goal]
+		if !ok {
+			return fmt.Errorf("Unknown goal %s", in.Goal)
+		}
+		p.Goal = goal
+	}
+	return nil
+}
+
+// CheckProof verifies that proof is a derivation of the empty sequent from rf
+// Every step is checked on its own terms, without the code of the search. A proof recording its settings
+// is checked with them in place of the ones of p. The first step which cannot be derived is reported with a *ProofError
+func (p *Prover) CheckProof(rf *RawFormula, proof *Proof) error {
+	if proof.Settings != nil {
+		p = p.withSettings(proof.Settings)
+	}
+	pr, err := p.parseProblem(rf)
+	if err != nil {
+		return err
+	}
+	if proof.Root == nil {
+		return &ProofError{Reason: "the proof has no root"}
+	}
+	if proof.Goal == nil {
+		return &ProofError{Reason: "the proof has no goal"}
+	}
+	if len(proof.Goal.Sequent.Left) != 0 || len(proof.Goal.Sequent.Right) != 0 {
+		return &ProofError{Step: proof.Goal.ID, Reason: "the goal is not the empty sequent"}
+	}
+
+	err = checkRoot(proof.Root, pr.Goal)
+	if err != nil {
+		return &ProofError{Step: proof.Root.ID, Reason: err.Error()}
+	}
+
+	rules := make(map[string]inferenceRule)
+	for _, r := range p.Rules {
+		rules[r.getName()] = r
+	}
+
+	// names holds the world symbols and terms used by the steps already checked,
+	// fresh the formula each new name was introduced for
+	names := make(map[string]bool)
+	fresh := make(map[string]string)
+	checked := make(map[*ProofStep]bool)
+	for _, s := range proof.Steps {
+		for _, k := range s.Premises {
+			if !checked[k] {
+				return &ProofError{Step: s.ID, Reason: fmt.Sprintf("premise %s is not derived before", k.ID)}
+			}
+		}
+		switch {
+		case s == proof.Root:
+			err = nil
+		case s.Rule == "":
+			err = checkPremise(s, proof.Root, pr.Premises)
+		case s.Rule == ruleAxiom:
+			err = checkAxiom(s, pr.Axioms)
+		case s.Rule == p.ResolutionRule.getName():
+			err = p.checkResolution(s)
+		default:
+			r, ok := rules[s.Rule]
+			if !ok {
+				err = fmt.Errorf("unknown rule %s", s.Rule)
+			} else if len(s.Premises) != 1 {
+				err = fmt.Errorf("rule %s needs 1 premise, got %d", s.Rule, len(s.Premises))
+			} else {
+				err = checkReduction(r, s, names, fresh)
+			}
+		}
+		if err != nil {
+			if perr, ok := err.(*ProofError); ok {
+				return perr
+			}
+			return &ProofError{Step: s.ID, Reason: err.Error()}
+		}
+		checked[s] = true
+		collectSequentNames(s.Sequent, names)
+	}
+	if !checked[proof.Goal] {
+		return &ProofError{Step: proof.Goal.ID, Reason: "the goal is not one of the steps"}
+	}
+	return nil
+}
+
+func checkRoot(s *ProofStep, top *formula) error {
+	if len(s.Premises) != 0 {
+		return fmt.Errorf("the root has premises")
+	}
+	if len(s.Sequent.Left) != 0 || len(s.Sequent.Right) != 1 {
+		return fmt.Errorf("the root is not the initial sequent")
+	}
+	f := s.Sequent.Right[0]
+	if len(f.Index.Symbols) != 1 || !f.Index.Symbols[0].Ground || len(f.Index.Symbols[0].Args) != 0 {
+		return fmt.Errorf("the root is not in a world constant")
+	}
+	g := copyTopFormulaLevel(top)
+	g.Index = f.Index
+	if g.String() != f.String() {
+		return fmt.Errorf("got %s want %s", f, g)
+	}
+	return nil
+}
+
+// checkPremise checks that the step is |h|_{i} <- with h one of the premises and i the root world
+func checkPremise(s *ProofStep, root *ProofStep, premises []*formula) error {
+	if len(s.Premises) != 0 || len(s.Sequent.Left) != 1 || len(s.Sequent.Right) != 0 {
+		return fmt.Errorf("it is neither derived nor a premise")
+	}
+	f := s.Sequent.Left[0]
+	for _, h := range premises {
+		g := copyTopFormulaLevel(h)
+		g.Index = root.Sequent.Right[0].Index
+		if g.String() == f.String() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a premise", f)
+}
+
+// checkAxiom checks that the step is |a|_{i} <- with a one of the axioms and i a world named by its premise
+func checkAxiom(s *ProofStep, axioms []*formula) error {
+	if len(s.Premises) != 1 || len(s.Sequent.Left) != 1 || len(s.Sequent.Right) != 0 {
+		return fmt.Errorf("it is not an axiom instance")
+	}
+	f := s.Sequent.Left[0]
+	named := false
+	for _, i := range worldsOf(s.Premises[0].Sequent) {
+		if i.String() == f.Index.String() {
+			named = true
+			break
+		}
+	}
+	if !named {
+		return fmt.Errorf("%s is not named by %s", &f.Index, s.Premises[0].ID)
+	}
+	for _, a := range axioms {
+		g := copyTopFormulaLevel(a)
+		g.Index = f.Index
+		if g.String() == f.String() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not an axiom", f)
+}
+
+// checkResolution checks a step of the resolution rule on its own terms: the literals it records must be
+// in the premises, the recorded world bindings must be admissible in the frame and the step must be what
+// resolution, paramodulation, reflexivity or factoring give from the premises under the recorded substitution
+func (p *Prover) checkResolution(s *ProofStep) error {
+	if len(s.Premises) != 2 {
+		return fmt.Errorf("rule %s needs 2 premises, got %d", s.Rule, len(s.Premises))
+	}
+	s1, s2 := s.Premises[0].Sequent, s.Premises[1].Sequent
+	if s.Complement == "" {
+		if s.Premises[0] != s.Premises[1] {
+			return fmt.Errorf("reflexivity needs the same premise twice")
+		}
+		return p.checkReflexivity(s, s1)
+	}
+	if s.Premises[0] == s.Premises[1] && p.isFactor(s, s1) {
+		return nil
+	}
+	for k1, f1 := range s1.Left {
+		if len(f1.Operands) != 0 || f1.String() != s.Principal {
+			continue
+		}
+		for _, left := range []bool{false, true} {
+			fs := s2.Right
+			if left {
+				fs = s2.Left
+			}
+			for k2, f2 := range fs {
+				if len(f2.Operands) != 0 || f2.String() != s.Complement {
+					continue
+				}
+				u, exists, err := p.R.recordedUnification(s, false, f1, f2)
+				if err != nil {
+					return err
+				}
+				if !left && exists && u.applyUnification(f1).String() == u.applyUnification(f2).String() {
+					// the resolved atom is removed together with its copies
+					n := &Sequent{
+						Left:  append(without(u.applyUnifications(s1.Left), u.applyUnification(f1)), u.applyUnifications(s2.Left)...),
+						Right: append(u.applyUnifications(s1.Right), without(u.applyUnifications(s2.Right), u.applyUnification(f2))...),
+					}
+					if sameLiterals(n, s.Sequent) {
+						return nil
+					}
+				}
+				if f1.Terminal == sEQUAL && len(f1.Args) == 2 && p.paramodulant(s, s1, s2, k1, k2, left, u, exists) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("it is not a resolvent of %s and %s", s.Premises[0].ID, s.Premises[1].ID)
+}
+
+// paramodulant checks that the step is the premises with the equality at k1 removed and one of its sides
+// replaced by the other one in the atom at k2, the equality must hold in a world, see anchor
+func (p *Prover) paramodulant(s *ProofStep, s1, s2 *Sequent, k1, k2 int, left bool, u *unification, exists bool) bool {
+	e := s1.Left[k1]
+	fs := s2.Right
+	if left {
+		fs = s2.Left
+	}
+	i, _ := u.applyToIndex(&e.Index)
+	j, _ := u.applyToIndex(&fs[k2].Index)
+	if !(exists && i.String() == j.String()) && !newWSolver(p.R, &e.Index).exists(make(map[string]string)) {
+		return false
+	}
+	rest := append(u.applyUnifications(s1.Left[:k1]), u.applyUnifications(s1.Left[k1+1:])...)
+	f := u.applyUnification(fs[k2])
+	found := false
+	for d := 0; d < 2 && !found; d++ {
+		from, to := u.applyToTerm(e.Args[d]), u.applyToTerm(e.Args[1-d])
+		positions(f.Args, nil, func(pos []int, t *term) {
+			if found || t.String() != from.String() {
+				return
+			}
+			g := copyTopFormulaLevel(f)
+			g.Args = replaceTerm(f.Args, pos, to)
+			rewritten := u.applyUnifications(fs)
+			rewritten[k2] = g
+			n := &Sequent{Left: rest, Right: u.applyUnifications(s1.Right)}
+			if left {
+				n.Left = append(append([]*formula{}, n.Left...), rewritten...)
+				n.Right = append(n.Right, u.applyUnifications(s2.Right)...)
+			} else {
+				n.Left = append(append([]*formula{}, n.Left...), u.applyUnifications(s2.Left)...)
+				n.Right = append(n.Right, rewritten...)
+			}
+			found = sameLiterals(n, s.Sequent)
+		})
+	}
+	return found
+}
+
+// isFactor checks that the step is its premise under the recorded substitution, which makes the recorded literals,
+// on the same side of the premise, equal
+func (p *Prover) isFactor(s *ProofStep, s1 *Sequent) bool {
+	for _, fs := range [][]*formula{s1.Left, s1.Right} {
+		for _, f1 := range fs {
+			if len(f1.Operands) != 0 || f1.String() != s.Principal {
+				continue
+			}
+			for _, f2 := range fs {
+				if len(f2.Operands) != 0 || f2.String() != s.Complement {
+					continue
+				}
+				u, _, err := p.R.recordedUnification(s, true, f1, f2)
+				if err != nil || u.applyUnification(f1).String() != u.applyUnification(f2).String() {
+					continue
+				}
+				n := &Sequent{Left: u.applyUnifications(s1.Left), Right: u.applyUnifications(s1.Right)}
+				if sameLiterals(n, s.Sequent) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkReflexivity checks that the step is its premise without an equality between terms the recorded substitution makes equal
+func (p *Prover) checkReflexivity(s *ProofStep, s1 *Sequent) error {
+	for k, f := range s1.Right {
+		if f.Terminal != sEQUAL || len(f.Args) != 2 || len(f.Operands) != 0 || f.String() != s.Principal {
+			continue
+		}
+		u, _, err := p.R.recordedUnification(s, false, f)
+		if err != nil {
+			return err
+		}
+		if u.applyToTerm(f.Args[0]).String() != u.applyToTerm(f.Args[1]).String() {
+			continue
+		}
+		n := &Sequent{
+			Left:  u.applyUnifications(s1.Left),
+			Right: append(u.applyUnifications(s1.Right[:k]), u.applyUnifications(s1.Right[k+1:])...),
+		}
+		if sameLiterals(n, s.Sequent) {
+			return nil
+		}
+	}
+	return fmt.Errorf("it is not an instance of reflexivity of %s", s.Premises[0].ID)
+}
+
+// recordedUnification builds the unification recorded by the step. Terms can be bound to the variables of the premises,
+// world variables of the literals can be bound to the worlds of the literals only if the frame admits it.
+// It also tells whether the world variables of the literals left unbound denote a world.
+// When instance is set two world variables can be bound as factoring does, see wunifyAt
+func (R *relation) recordedUnification(s *ProofStep, instance bool, literals ...*formula) (*unification, bool, error) {
+	vars := make(map[string]bool)
+	for _, k := range s.Premises {
+		for _, f := range append(append([]*formula{}, k.Sequent.Left...), k.Sequent.Right...) {
+			collectVariables(f, vars)
+		}
+	}
+	indexes := []*worldindex{}
+	for _, f := range literals {
+		indexes = append(indexes, &f.Index)
+	}
+	solver := newWSolver(R, indexes...)
+	solver.instances = instance
+
+	u := &unification{Map: make(map[string]*term), Paths: make(map[string][]*worldsymbol)}
+	for k, v := range s.Substitution {
+		if solver.isVar(k) {
+			continue
+		}
+		if !vars[k] {
+			return nil, false, fmt.Errorf("%s is not a variable of the premises", k)
+		}
+		t, n, err := readTerm(v)
+		if err != nil || n != len(v) {
+			return nil, false, fmt.Errorf("%s is not a term", v)
+		}
+		u.Map[k] = withVariables(t, vars)
+	}
+	// The world a variable is bound to is the world symbol of the literals the substitution turns into the recorded term,
+	// the world variables of skolem functions are replaced by the worlds recorded for them
+	all := &unification{Map: make(map[string]*term)}
+	for k, t := range u.Map {
+		all.Map[k] = t
+	}
+	for k, v := range s.Substitution {
+		if !solver.isVar(k) {
+			continue
+		}
+		t, n, err := readTerm(v)
+		if err != nil || n != len(v) {
+			return nil, false, fmt.Errorf("%s is not a world", v)
+		}
+		all.Map[k] = withVariables(t, vars)
+	}
+	b := make(map[string]string)
+	for k, v := range s.Substitution {
+		if !solver.isVar(k) {
+			continue
+		}
+		for _, w := range solver.order {
+			if u.applyToTerm(solver.symbols[w].term()).String() == v {
+				b[k] = w
+				break
+			}
+		}
+		for _, w := range solver.order {
+			if _, ok := b[k]; ok {
+				break
+			}
+			// a skolem function of world variables, which are bound as well
+			if !solver.isVar(w) && all.applyToTerm(solver.symbols[w].term()).String() == v {
+				b[k] = w
+			}
+		}
+		if _, ok := b[k]; !ok {
+			return nil, false, fmt.Errorf("%s is not a world of the resolved literals", v)
+		}
+	}
+	for k := range s.Worlds {
+		if _, ok := b[k]; !ok {
+			return nil, false, fmt.Errorf("world %s of %s has no binding", s.Worlds[k], k)
+		}
+	}
+	for k := range b {
+		if !solver.admissible(b, k) {
+			return nil, false, fmt.Errorf("the frame does not admit %s/%s", k, s.Substitution[k])
+		}
+		u.Map[k] = solver.symbols[solver.resolve(b, k)].term()
+	}
+	for k := range u.Map {
+		u.Map[k] = u.applyToTerm(u.Map[k])
+	}
+	for k := range b {
+		path := []*worldsymbol{}
+		for _, a := range solver.ancestors(b, solver.resolve(b, k)) {
+			path = append(path, solver.symbols[a])
+		}
+		u.Paths[k] = u.applyToSymbols(path)
+		if got := (&worldindex{Symbols: u.Paths[k]}).String(); got != s.Worlds[k] {
+			return nil, false, fmt.Errorf("got world %s want %s for %s", s.Worlds[k], got, k)
+		}
+	}
+	return u, solver.exists(b), nil
+}
+
+// collectVariables marks the variables of the arguments and of the world indexes of f
+func collectVariables(f *formula, vars map[string]bool) {
+	var walk func(ts []*term)
+	walk = func(ts []*term) {
+		for _, t := range ts {
+			if t.IsVar {
+				vars[t.Value] = true
+			}
+			walk(t.Args)
+		}
+	}
+	walk(f.Args)
+	for _, w := range f.Index.Symbols {
+		walk(w.Args)
+	}
+	for _, o := range f.Operands {
+		collectVariables(o, vars)
+	}
+}
+
+// withVariables marks as variables the names of t which are variables of the premises
+func withVariables(t *term, vars map[string]bool) *term {
+	n := &term{Value: t.Value, IsVar: len(t.Args) == 0 && vars[t.Value]}
+	for _, a := range t.Args {
+		n.Args = append(n.Args, withVariables(a, vars))
+	}
+	return n
+}
+
+// positions calls fn on every subterm of ts, variables included, together with its position
+func positions(ts []*term, pos []int, fn func(pos []int, t *term)) {
+	for i, t := range ts {
+		p := append(append([]int{}, pos...), i)
+		fn(p, t)
+		positions(t.Args, p, fn)
+	}
+}
+
+// without returns fs without the copies of f
+func without(fs []*formula, f *formula) []*formula {
+	out := []*formula{}
+	for _, g := range fs {
+		if g.String() != f.String() {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// sameLiterals compares two sequents as clauses, ignoring the order and the copies of the formulas
+func sameLiterals(a, b *Sequent) bool {
+	set := func(fs []*formula) string {
+		seen := make(map[string]bool)
+		out := []string{}
+		for _, f := range fs {
+			if k := f.String(); !seen[k] {
+				seen[k] = true
+				out = append(out, k)
+			}
+		}
+		sort.Strings(out)
+		return strings.Join(out, ", ")
+	}
+	return set(a.Left) == set(b.Left) && set(a.Right) == set(b.Right)
+}
+
+// checkReduction checks the steps introducing names with R7, R8, R9, R10 and R21, R22, R23, R24 directly,
+// the names are taken from the step and must not be used by previous steps, unless they were introduced
+// for the same formula. The other rules only rewrite a connective, their steps are checked against the shape of the rule
+func checkReduction(r inferenceRule, s *ProofStep, names map[string]bool, fresh map[string]string) error {
+	premise := s.Premises[0].Sequent
+	switch r.(type) {
+	case r7, r9, r22, r24:
+		if len(premise.Right) < 1 {
+			return fmt.Errorf("rule %s does not apply to %s", s.Rule, s.Premises[0].ID)
+		}
+		t, err := newFormula(premise.Right[1:], s.Sequent.Right)
+		if err != nil {
+			return err
+		}
+		if !sameFormulas(premise.Left, s.Sequent.Left) {
+			return fmt.Errorf("the left side changed")
+		}
+		return checkFresh(r, premise.Right[0], t, names, fresh)
+	case r8, r10, r21, r23:
+		l := len(premise.Left)
+		if l < 1 {
+			return fmt.Errorf("rule %s does not apply to %s", s.Rule, s.Premises[0].ID)
+		}
+		t, err := newFormula(premise.Left[:l-1], s.Sequent.Left)
+		if err != nil {
+			return err
+		}
+		if !sameFormulas(premise.Right, s.Sequent.Right) {
+			return fmt.Errorf("the right side changed")
+		}
+		return checkFresh(r, premise.Left[l-1], t, names, fresh)
+	}
+	shape, ok := shapeOf(r)
+	if !ok {
+		return fmt.Errorf("rule %s cannot be checked", s.Rule)
+	}
+	return checkShape(shape, s)
+}
+
+// shapeOf returns the shape of the rules rewriting a connective, as stated by their comments in rules.go
+func shapeOf(r inferenceRule) (reductionShape, bool) {
+	switch r.(type) {
+	case r2:
+		return reductionShape{left: true, op: sIMPLY, toLeft: []int{1}, toRight: []int{0}}, true
+	case r3:
+		return reductionShape{op: sIMPLY, toRight: []int{1}}, true
+	case r4:
+		return reductionShape{op: sIMPLY, toLeft: []int{0}}, true
+	case r5:
+		return reductionShape{left: true, op: sNOT, toRight: []int{0}}, true
+	case r6:
+		return reductionShape{op: sNOT, toLeft: []int{0}}, true
+	case r11:
+		return reductionShape{left: true, op: sAND, toLeft: []int{0}}, true
+	case r12:
+		return reductionShape{left: true, op: sAND, toLeft: []int{1}}, true
+	case r13:
+		return reductionShape{op: sAND, toRight: []int{0, 1}}, true
+	case r14:
+		return reductionShape{left: true, op: sOR, toLeft: []int{0, 1}}, true
+	case r15:
+		return reductionShape{op: sOR, toRight: []int{0}}, true
+	case r16:
+		return reductionShape{op: sOR, toRight: []int{1}}, true
+	case r17:
+		return reductionShape{left: true, op: sIFF, toLeft: []int{1}, toRight: []int{0}}, true
+	case r18:
+		return reductionShape{left: true, op: sIFF, toLeft: []int{0}, toRight: []int{1}}, true
+	case r19:
+		return reductionShape{op: sIFF, toLeft: []int{0, 1}}, true
+	case r20:
+		return reductionShape{op: sIFF, toRight: []int{0, 1}}, true
+	}
+	return reductionShape{}, false
+}
+
+// checkShape checks that the sequent of s is its premise with the principal replaced as stated by shape,
+// each operand is given the world index of the principal
+func checkShape(shape reductionShape, s *ProofStep) error {
+	premise := s.Premises[0].Sequent
+	var f *formula
+	left := append([]*formula{}, premise.Left...)
+	right := append([]*formula{}, premise.Right...)
+	if shape.left {
+		if len(left) < 1 {
+			return fmt.Errorf("rule %s does not apply to %s", s.Rule, s.Premises[0].ID)
+		}
+		f, left = left[len(left)-1], left[:len(left)-1]
+	} else {
+		if len(right) < 1 {
+			return fmt.Errorf("rule %s does not apply to %s", s.Rule, s.Premises[0].ID)
+		}
+		f, right = right[0], right[1:]
+	}
+	if !f.is(shape.op) {
+		return fmt.Errorf("%s is not a %s formula", f, shape.op)
+	}
+	for _, k := range append(append([]int{}, shape.toLeft...), shape.toRight...) {
+		if k >= len(f.Operands) {
+			return fmt.Errorf("%s has no operand %d", f, k+1)
+		}
+	}
+	operand := func(k int) *formula {
+		t := copyTopFormulaLevel(f.Operands[k])
+		t.Index = f.Index
+		return t
+	}
+	for _, k := range shape.toLeft {
+		left = append(left, operand(k))
+	}
+	for _, k := range shape.toRight {
+		right = append(right, operand(k))
+	}
+	if !sameFormulas(left, s.Sequent.Left) {
+		return fmt.Errorf("got %s want %s on the left", sortedStrings(s.Sequent.Left), sortedStrings(left))
+	}
+	if !sameFormulas(right, s.Sequent.Right) {
+		return fmt.Errorf("got %s want %s on the right", sortedStrings(s.Sequent.Right), sortedStrings(right))
+	}
+	return nil
+}
+
+// checkFresh checks that t is obtained from f by rule r using new names, or the names introduced
+// for f before, and records the formula each constant and skolem function was introduced for
+func checkFresh(r inferenceRule, f, t *formula, names map[string]bool, fresh map[string]string) error {
+	isNew := func(name string) bool {
+		if isVariableRule(r) {
+			return !names[name]
+		}
+		if k, ok := fresh[name]; ok {
+			return k == f.String()
+		}
+		if names[name] {
+			return false
+		}
+		fresh[name] = f.String()
+		return true
+	}
+	switch r.(type) {
+	case r7, r8, r21, r22:
+		op := sBOX
+		switch r.(type) {
+		case r21, r22:
+			op = sDIAMOND
+		}
+		if !f.is(op) {
+			return fmt.Errorf("%s is not a %s formula", f, op)
+		}
+		if len(t.Index.Symbols) != len(f.Index.Symbols)+1 {
+			return fmt.Errorf("%s is not in a world accessible from %s", t, &f.Index)
+		}
+		ns := t.Index.Symbols[0]
+		if !isNew(ns.Value) {
+			return fmt.Errorf("%s is not a new world symbol", ns.Value)
+		}
+		g := copyTopFormulaLevel(f.Operands[0])
+		g.Index = f.Index
+		if isVariableRule(r) {
+			if ns.Ground || len(ns.Args) > 0 {
+				return fmt.Errorf("%s is not a world variable", ns)
+			}
+		} else if !ns.Ground {
+			return fmt.Errorf("%s is not a world constant", ns)
+		} else if f.Index.isGround() && len(g.GetAllFreeVars(nil)) == 0 {
+			if len(ns.Args) > 0 {
+				return fmt.Errorf("%s is not a world constant", ns)
+			}
+		} else if termArrayToString(ns.Args) != termArrayToString(skolemArgs(g, nil)) {
+			return fmt.Errorf("got %s want %s as skolem arguments", termArrayToString(ns.Args), termArrayToString(skolemArgs(g, nil)))
+		}
+		g.Index = worldindex{append([]*worldsymbol{ns}, f.Index.Symbols...)}
+		if g.String() != t.String() {
+			return fmt.Errorf("got %s want %s", t, g)
+		}
+	case r9, r10, r23, r24:
+		op := sFORALL
+		switch r.(type) {
+		case r23, r24:
+			op = sEXISTS
+		}
+		if !f.is(op) {
+			return fmt.Errorf("%s is not a %s formula", f, op)
+		}
+		g := copyTopFormulaLevel(f.Operands[len(f.Operands)-1])
+		g.Index = f.Index
+		vars := make(map[string]bool)
+		for _, v := range f.Vars {
+			vars[v] = true
+		}
+		m := make(map[string]*term)
+		if !matchFormula(g, t, vars, m) {
+			return fmt.Errorf("%s is not an instance of %s", t, f)
+		}
+		used := make(map[string]bool)
+		for _, v := range f.Vars {
+			k, ok := m[v]
+			if !ok {
+				continue
+			}
+			if used[k.Value] || !isNew(k.Value) {
+				return fmt.Errorf("%s is not a new name", k.Value)
+			}
+			used[k.Value] = true
+			if isVariableRule(r) {
+				if !k.IsVar || len(k.Args) > 0 {
+					return fmt.Errorf("%s is not a variable", k)
+				}
+			} else if k.IsVar {
+				return fmt.Errorf("%s is not a constant", k)
+			} else if g.Index.isGround() && len(g.GetAllFreeVars(&vars)) == 0 {
+				if len(k.Args) > 0 {
+					return fmt.Errorf("%s is not a constant", k)
+				}
+			} else if termArrayToString(k.Args) != termArrayToString(skolemArgs(g, &vars)) {
+				return fmt.Errorf("got %s want %s as skolem arguments", termArrayToString(k.Args), termArrayToString(skolemArgs(g, &vars)))
+			}
+		}
+	}
+	return nil
+}
+
+// isVariableRule checks that r introduces variables rather than constants or skolem functions
+func isVariableRule(r inferenceRule) bool {
+	switch r.(type) {
+	case r8, r10, r22, r24:
+		return true
+	}
+	return false
+}
+
+// skolemArgs returns the arguments given by GetSkolemFunctionOf to a new skolem function
+func skolemArgs(f *formula, nonFreeVars *map[string]bool) []*term {
+	args := []*term{}
+	for _, s := range f.Index.Symbols {
+		if !s.Ground {
+			args = append(args, s.term())
+		}
+	}
+	for _, v := range f.GetAllFreeVars(nonFreeVars) {
+		args = append(args, &term{Value: v, IsVar: true})
+	}
+	return args
+}
+
+// matchFormula checks that b is a with the variables in vars replaced by the terms in m
+func matchFormula(a, b *formula, vars map[string]bool, m map[string]*term) bool {
+	if a.Terminal != b.Terminal || a.Agent != b.Agent || len(a.Operands) != len(b.Operands) || len(a.Args) != len(b.Args) {
+		return false
+	}
+	if a.Index.String() != b.Index.String() || strings.Join(a.Vars, ",") != strings.Join(b.Vars, ",") {
+		return false
+	}
+	for i := range a.Args {
+		if !matchTerm(a.Args[i], b.Args[i], vars, m) {
+			return false
+		}
+	}
+	inner := vars
+	if len(a.Vars) > 0 {
+		// Quantified variables shadow the outer ones
+		inner = make(map[string]bool)
+		for k, v := range vars {
+			inner[k] = v
+		}
+		for _, v := range a.Vars {
+			delete(inner, v)
+		}
+	}
+	for i := range a.Operands {
+		if !matchFormula(a.Operands[i], b.Operands[i], inner, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(a, b *term, vars map[string]bool, m map[string]*term) bool {
+	if a.IsVar && vars[a.Value] && len(a.Args) == 0 {
+		if k, ok := m[a.Value]; ok {
+			return k.String() == b.String()
+		}
+		m[a.Value] = b
+		return true
+	}
+	if a.Value != b.Value || a.IsVar != b.IsVar || len(a.Args) != len(b.Args) {
+		return false
+	}
+	for i := range a.Args {
+		if !matchTerm(a.Args[i], b.Args[i], vars, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// newFormula returns the only formula of after which is not in before
+func newFormula(before, after []*formula) (*formula, error) {
+	count := make(map[string]int)
+	for _, f := range before {
+		count[f.String()] = count[f.String()] + 1
+	}
+	out := []*formula{}
+	for _, f := range after {
+		if count[f.String()] > 0 {
+			count[f.String()] = count[f.String()] - 1
+		} else {
+			out = append(out, f)
+		}
+	}
+	for k, c := range count {
+		if c > 0 {
+			return nil, fmt.Errorf("%s is missing", k)
+		}
+	}
+	if len(out) != 1 {
+		return nil, fmt.Errorf("got %d new formulas want 1", len(out))
+	}
+	return out[0], nil
+}
+
+func sortedStrings(fs []*formula) string {
+	out := []string{}
+	for _, f := range fs {
+		out = append(out, f.String())
+	}
+	sort.Strings(out)
+	return strings.Join(out, ", ")
+}
+
+func sameFormulas(a, b []*formula) bool {
+	return len(a) == len(b) && sortedStrings(a) == sortedStrings(b)
+}
+
+// sameSequent compares two sequents ignoring the order of the formulas
+func sameSequent(a, b *Sequent) bool {
+	return sameFormulas(a.Left, b.Left) && sameFormulas(a.Right, b.Right)
+}
+
+func collectSequentNames(s *Sequent, names map[string]bool) {
+	for _, f := range append(append([]*formula{}, s.Left...), s.Right...) {
+		collectFormulaNames(f, names)
+	}
+}
+
+func collectFormulaNames(f *formula, names map[string]bool) {
+	for _, s := range f.Index.Symbols {
+		names[s.Value] = true
+		collectTermNames(s.Args, names)
+	}
+	collectTermNames(f.Args, names)
+	for _, o := range f.Operands {
+		collectFormulaNames(o, names)
+	}
+}
+
+func collectTermNames(ts []*term, names map[string]bool) {
+	for _, t := range ts {
+		names[t.Value] = true
+		collectTermNames(t.Args, names)
+	}
+}
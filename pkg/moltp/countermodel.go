@@ -0,0 +1,380 @@
+package moltp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxCountermodelSteps bounds the number of partial valuations tried while looking for a countermodel
+const maxCountermodelSteps = 100000
+
+// truth values used while evaluating a formula under a partial valuation
+const (
+	tvFalse = iota
+	tvTrue
+	tvUnknown
+)
+
+type valuationsearch struct {
+	ctx       context.Context
+	f         *formula
+	axioms    []*formula
+	worlds    []string
+	relations map[string]map[string][]string // relation of each agent, the default one has no name
+	atoms     []string
+	valuation map[string]map[string]int
+	steps     int
+}
+
+func (m *Countermodel) String() string {
+	relation := []string{}
+	valuation := []string{}
+	for _, w := range m.Worlds {
+		for _, v := range m.Relation[w] {
+			relation = append(relation, fmt.Sprintf("%s -> %s", w, v))
+		}
+		valuation = append(valuation, fmt.Sprintf("%s: {%s}", w, strings.Join(m.Valuation[w], ", ")))
+	}
+	agents := []string{}
+	for k := range m.Agents {
+		agents = append(agents, k)
+	}
+	sort.Strings(agents)
+	for _, k := range agents {
+		edges := []string{}
+		for _, w := range m.Worlds {
+			for _, v := range m.Agents[k][w] {
+				edges = append(edges, fmt.Sprintf("%s -> %s", w, v))
+			}
+		}
+		relation = append(relation, fmt.Sprintf("%s: {%s}", k, strings.Join(edges, ", ")))
+	}
+	return fmt.Sprintf("Worlds: %s; Relation: %s; Valuation: %s",
+		strings.Join(m.Worlds, ", "),
+		strings.Join(relation, ", "),
+		strings.Join(valuation, ", "))
+}
+
+// collectAgents returns the agents of the indexed modalities of f
+func collectAgents(f *formula, seen map[string]bool, agents []string) []string {
+	if f.Agent != "" && !seen[f.Agent] {
+		seen[f.Agent] = true
+		agents = append(agents, f.Agent)
+	}
+	for _, o := range f.Operands {
+		agents = collectAgents(o, seen, agents)
+	}
+	return agents
+}
+
+// isPropositional checks that the formula has no quantifiers, no equality and no explicit world index below the top level
+func isPropositional(f *formula, top bool) bool {
+	if !top && len(f.Index.Symbols) > 0 {
+		return false
+	}
+	if f.is(sFORALL) || f.is(sEXISTS) || f.Terminal == sEQUAL {
+		return false
+	}
+	for _, o := range f.Operands {
+		if !isPropositional(o, false) {
+			return false
+		}
+	}
+	return true
+}
+
+func atomName(f *formula) string {
+	if len(f.Args) > 0 {
+		return fmt.Sprintf("%s(%s)", f.Terminal, termArrayToString(f.Args))
+	}
+	return f.Terminal
+}
+
+func collectAtoms(f *formula, seen map[string]bool, atoms []string) []string {
+	if len(f.Operands) == 0 {
+		a := atomName(f)
+		if !seen[a] {
+			seen[a] = true
+			atoms = append(atoms, a)
+		}
+		return atoms
+	}
+	for _, o := range f.Operands {
+		atoms = collectAtoms(o, seen, atoms)
+	}
+	return atoms
+}
+
+// findCountermodel builds a Kripke model whose worlds are the world indexes named by the sequents
+// of the last search and looks for a valuation making the premises true and the goal false in the root world
+// and the axioms true in every world.
+// It returns nil if the formulas are not propositional, no such valuation was found or ctx is done.
+// It returns nil for frames including the relation of another agent too, like LTL, since the relation
+// containing next is not built as the closure of next, so that the model might not belong to the system
+func (p *Prover) findCountermodel(ctx context.Context, pr *problem) *Countermodel {
+	if p.R.includes() {
+		return nil
+	}
+	f := pr.Goal
+	for _, h := range pr.Premises {
+		g := copyTopFormulaLevel(h)
+		g.Index = worldindex{}
+		k := copyTopFormulaLevel(f)
+		k.Index = worldindex{}
+		f = &formula{Terminal: sIMPLY, Operands: []*formula{g, k}, Index: f.Index}
+	}
+	if !isPropositional(f, true) {
+		return nil
+	}
+	for _, a := range pr.Axioms {
+		if !isPropositional(a, false) {
+			return nil
+		}
+	}
+	root := "0"
+	if len(f.Index.Symbols) > 0 {
+		root = f.Index.String()
+	}
+
+	// Every world index and all its parents are worlds
+	parents := make(map[string]string)
+	reached := make(map[string]string)
+	worlds := []string{root}
+	seen := map[string]bool{root: true}
+	var addIndex func(symbols []*worldsymbol)
+	addIndex = func(symbols []*worldsymbol) {
+		if len(symbols) < 2 {
+			return
+		}
+		i := &worldindex{Symbols: symbols}
+		w := i.String()
+		if seen[w] {
+			return
+		}
+		addIndex(symbols[1:])
+		seen[w] = true
+		worlds = append(worlds, w)
+		parents[w] = (&worldindex{Symbols: symbols[1:]}).String()
+		reached[w] = symbols[0].Agent
+	}
+	var addFormula func(g *formula)
+	addFormula = func(g *formula) {
+		addIndex(g.Index.Symbols)
+		for _, o := range g.Operands {
+			addFormula(o)
+		}
+	}
+	names := []string{}
+	for k := range p.sequents {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, g := range append(append([]*formula{}, p.sequents[k].Left...), p.sequents[k].Right...) {
+			addFormula(g)
+		}
+	}
+	sort.SliceStable(worlds, func(i, j int) bool {
+		return strings.Count(worlds[i], ":") < strings.Count(worlds[j], ":")
+	})
+
+	seenAgents := map[string]bool{"": true}
+	agents := collectAgents(f, seenAgents, []string{""})
+	for _, g := range pr.Axioms {
+		agents = collectAgents(g, seenAgents, agents)
+	}
+	for _, w := range worlds {
+		if k, ok := reached[w]; ok && !seenAgents[k] {
+			seenAgents[k] = true
+			agents = append(agents, k)
+		}
+	}
+	relations := make(map[string]map[string][]string)
+	for _, k := range agents {
+		relations[k] = p.accessibility(worlds, parents, reached, k)
+	}
+
+	s := &valuationsearch{
+		ctx:       ctx,
+		f:         f,
+		axioms:    pr.Axioms,
+		worlds:    worlds,
+		relations: relations,
+		atoms:     collectAtoms(f, make(map[string]bool), []string{}),
+		valuation: make(map[string]map[string]int),
+	}
+	seenAtoms := make(map[string]bool)
+	for _, k := range s.atoms {
+		seenAtoms[k] = true
+	}
+	for _, a := range pr.Axioms {
+		s.atoms = collectAtoms(a, seenAtoms, s.atoms)
+	}
+	for _, w := range worlds {
+		s.valuation[w] = make(map[string]int)
+		for _, k := range s.atoms {
+			s.valuation[w][k] = tvUnknown
+		}
+	}
+	if !s.search(root, 0) {
+		return nil
+	}
+
+	m := &Countermodel{Root: root, Worlds: worlds, Relation: relations[""], Valuation: make(map[string][]string)}
+	for _, k := range agents[1:] {
+		if m.Agents == nil {
+			m.Agents = make(map[string]map[string][]string)
+		}
+		m.Agents[k] = relations[k]
+	}
+	for _, w := range worlds {
+		m.Valuation[w] = []string{}
+		for _, k := range s.atoms {
+			if s.valuation[w][k] == tvTrue {
+				m.Valuation[w] = append(m.Valuation[w], k)
+			}
+		}
+	}
+	return m
+}
+
+// accessibility builds the relation of the agent from the worlds reached by the agent,
+// or by an agent its frame includes, closed under the properties of its frame
+func (p *Prover) accessibility(worlds []string, parents, reached map[string]string, agent string) map[string][]string {
+	fr := p.R.frame(agent)
+	a := make(map[string]map[string]bool)
+	for _, w := range worlds {
+		a[w] = make(map[string]bool)
+	}
+	for w, parent := range parents {
+		if _, ok := a[parent]; ok && (reached[w] == agent || fr.includes(reached[w])) {
+			a[parent][w] = true
+		}
+	}
+	fr.close(worlds, a)
+	if fr.Serial {
+		// Worlds without accessible worlds are made accessible from themselves
+		for _, w := range worlds {
+			if len(a[w]) == 0 {
+				a[w][w] = true
+			}
+		}
+		fr.close(worlds, a)
+	}
+
+	relation := make(map[string][]string)
+	for _, w := range worlds {
+		relation[w] = []string{}
+		for _, v := range worlds {
+			if a[w][v] {
+				relation[w] = append(relation[w], v)
+			}
+		}
+	}
+	return relation
+}
+
+// search assigns the atoms world by world until the formula is false in the root world
+// and the axioms are true in every world, atoms left unassigned are false.
+// It gives up when ctx is done
+func (s *valuationsearch) search(root string, next int) bool {
+	s.steps = s.steps + 1
+	if s.steps > maxCountermodelSteps {
+		return false
+	}
+	select {
+	case <-s.ctx.Done():
+		return false
+	default:
+	}
+	v := s.eval(s.f, root)
+	for _, a := range s.axioms {
+		for _, w := range s.worlds {
+			v = or3(v, not3(s.eval(a, w)))
+		}
+	}
+	switch v {
+	case tvFalse:
+		for _, w := range s.worlds {
+			for _, k := range s.atoms {
+				if s.valuation[w][k] == tvUnknown {
+					s.valuation[w][k] = tvFalse
+				}
+			}
+		}
+		return true
+	case tvTrue:
+		return false
+	}
+	if next >= len(s.worlds)*len(s.atoms) {
+		return false
+	}
+	w := s.worlds[next/len(s.atoms)]
+	k := s.atoms[next%len(s.atoms)]
+	for _, v := range []int{tvFalse, tvTrue} {
+		s.valuation[w][k] = v
+		if s.search(root, next+1) {
+			return true
+		}
+	}
+	s.valuation[w][k] = tvUnknown
+	return false
+}
+
+// eval evaluates f in the world w using Kleene three valued logic
+func (s *valuationsearch) eval(f *formula, w string) int {
+	if len(f.Operands) == 0 {
+		return s.valuation[w][atomName(f)]
+	}
+	switch f.Terminal {
+	case sNOT:
+		return not3(s.eval(f.Operands[0], w))
+	case sIMPLY:
+		return or3(not3(s.eval(f.Operands[0], w)), s.eval(f.Operands[1], w))
+	case sOR:
+		return or3(s.eval(f.Operands[0], w), s.eval(f.Operands[1], w))
+	case sAND:
+		return not3(or3(not3(s.eval(f.Operands[0], w)), not3(s.eval(f.Operands[1], w))))
+	case sIFF:
+		a := s.eval(f.Operands[0], w)
+		b := s.eval(f.Operands[1], w)
+		return not3(or3(not3(or3(not3(a), b)), not3(or3(not3(b), a))))
+	case sBOX, sDIAMOND:
+		out := tvTrue
+		if f.Terminal == sDIAMOND {
+			out = tvFalse
+		}
+		for _, v := range s.relations[f.Agent][w] {
+			r := s.eval(f.Operands[0], v)
+			if f.Terminal == sBOX {
+				out = not3(or3(not3(out), not3(r)))
+			} else {
+				out = or3(out, r)
+			}
+		}
+		return out
+	}
+	return tvUnknown
+}
+
+func not3(a int) int {
+	switch a {
+	case tvTrue:
+		return tvFalse
+	case tvFalse:
+		return tvTrue
+	}
+	return tvUnknown
+}
+
+func or3(a, b int) int {
+	if a == tvTrue || b == tvTrue {
+		return tvTrue
+	}
+	if a == tvFalse && b == tvFalse {
+		return tvFalse
+	}
+	return tvUnknown
+}
@@ -0,0 +1,101 @@
+package moltp
+
+import (
+	"fmt"
+	"strings"
+)
+
+func newFormulaTable() *formulaTable {
+	return &formulaTable{nodes: make(map[string]*formula), byID: []*formula{nil}}
+}
+
+// owns checks that f is a node of the table, copies of interned formulas are not
+func (t *formulaTable) owns(f *formula) bool {
+	return f.id > 0 && f.id < len(t.byID) && t.byID[f.id] == f
+}
+
+// intern returns the node of the table structurally equal to f, adding it when it is missing.
+// Only the formulas which are not interned yet are visited
+func (t *formulaTable) intern(f *formula) *formula {
+	if t.owns(f) {
+		return f
+	}
+	g := copyTopFormulaLevel(f)
+	g.nodes = 1
+	for i, o := range g.Operands {
+		g.Operands[i] = t.intern(o)
+		g.nodes = g.nodes + g.Operands[i].nodes
+	}
+	k := nodeKey(g)
+	if n, ok := t.nodes[k]; ok {
+		return n
+	}
+	g.table = t
+	g.id = len(t.byID)
+	t.byID = append(t.byID, g)
+	t.nodes[k] = g
+	return g
+}
+
+// internSequent replaces the formulas of s by the nodes of the table
+func (t *formulaTable) internSequent(s *Sequent) {
+	left := make([]*formula, len(s.Left))
+	for i, f := range s.Left {
+		left[i] = t.intern(f)
+	}
+	right := make([]*formula, len(s.Right))
+	for i, f := range s.Right {
+		right[i] = t.intern(f)
+	}
+	s.Left = left
+	s.Right = right
+}
+
+// nodeKey identifies a formula whose operands are interned by the ids of its operands
+func nodeKey(f *formula) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%s|%s|%s|", f.Terminal, f.Agent, f.Index.String(), strings.Join(f.Vars, ","))
+	writeTermsKey(&b, f.Args)
+	for _, o := range f.Operands {
+		fmt.Fprintf(&b, "|%d", o.id)
+	}
+	return b.String()
+}
+
+func writeTermsKey(b *strings.Builder, ts []*term) {
+	for i, t := range ts {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if t.IsVar {
+			b.WriteByte('?')
+		}
+		b.WriteString(t.Value)
+		if len(t.Args) > 0 {
+			b.WriteByte('(')
+			writeTermsKey(b, t.Args)
+			b.WriteByte(')')
+		}
+	}
+}
+
+// sequentKey identifies a sequent whose formulas are interned by the ids of its formulas
+func sequentKey(s *Sequent) string {
+	var b strings.Builder
+	for _, f := range s.Left {
+		fmt.Fprintf(&b, "%d ", f.id)
+	}
+	b.WriteString("<-")
+	for _, f := range s.Right {
+		fmt.Fprintf(&b, " %d", f.id)
+	}
+	return b.String()
+}
+
+// sameFormula checks that f and g are structurally equal, formulas interned by the same table are compared by pointer
+func sameFormula(f, g *formula) bool {
+	if f.table != nil && f.table == g.table && f.table.owns(f) && g.table.owns(g) {
+		return f == g
+	}
+	return f.String() == g.String()
+}
@@ -0,0 +1,127 @@
+package moltp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// latexOperators holds the LaTeX command of each operator
+var latexOperators = map[string]string{
+	sBOX:     "\\Box",
+	sDIAMOND: "\\Diamond",
+	sNEXT:    "\\bigcirc",
+	sEXISTS:  "\\exists",
+	sFORALL:  "\\forall",
+	sIFF:     "\\iff",
+	sIMPLY:   "\\to",
+	sAND:     "\\land",
+	sOR:      "\\lor",
+	sNOT:     "\\lnot",
+}
+
+// Binding strength of the operators as read by the parser, binary operators are right associative
+// quantifiers extend as far to the right as possible
+const (
+	levelQuantifier = iota
+	levelIff
+	levelImply
+	levelAnd
+	levelOr
+	levelUnary
+	levelAtom
+)
+
+// level returns the binding strength of the top operator of f
+func (f *formula) level() int {
+	if len(f.Index.Symbols) > 0 || len(f.Operands) == 0 {
+		// indexed formulas are enclosed in brackets
+		return levelAtom
+	}
+	switch f.Terminal {
+	case sFORALL, sEXISTS:
+		return levelQuantifier
+	case sIFF:
+		return levelIff
+	case sIMPLY:
+		return levelImply
+	case sAND:
+		return levelAnd
+	case sOR:
+		return levelOr
+	case sNOT, sBOX, sDIAMOND:
+		return levelUnary
+	}
+	return levelAtom
+}
+
+// latex returns f in the notation read by the parser using as few brackets as possible
+// world indexes are printed as subscripts
+func (f *formula) latex() string {
+	return f.latexIn(levelQuantifier, true)
+}
+
+// latexIn prints f as an operand requiring at least the binding strength min,
+// last is false when something follows f, so that f cannot end with a quantifier
+func (f *formula) latexIn(min int, last bool) string {
+	l := f.level()
+	// a quantifier at the end of an operand does not need brackets
+	open := l == levelQuantifier && last && min <= levelUnary
+	if l < min && !open || !last && f.endsWithQuantifier() {
+		return fmt.Sprintf("( %s )", f.latexIn(levelQuantifier, true))
+	}
+	if len(f.Index.Symbols) > 0 {
+		g := *f
+		g.Index = worldindex{}
+		if len(f.Operands) == 0 && f.Terminal != sEQUAL {
+			return fmt.Sprintf("%s_{%s}", g.latexIn(levelAtom, true), &f.Index)
+		}
+		return fmt.Sprintf("( %s )_{%s}", g.latexIn(levelQuantifier, true), &f.Index)
+	}
+	switch l {
+	case levelQuantifier:
+		vars := []string{}
+		for _, v := range f.Operands[:len(f.Operands)-1] {
+			vars = append(vars, v.Terminal)
+		}
+		return fmt.Sprintf("%s %s\\, %s", latexOperators[f.Terminal], strings.Join(vars, ", "), f.Operands[len(f.Operands)-1].latexIn(levelQuantifier, last))
+	case levelIff, levelImply, levelAnd, levelOr:
+		return fmt.Sprintf("%s %s %s", f.Operands[0].latexIn(l+1, false), latexOperators[f.Terminal], f.Operands[1].latexIn(l, last))
+	case levelUnary:
+		op := latexOperators[f.Terminal]
+		if f.Terminal == sBOX && f.Agent == agentNext {
+			op = latexOperators[sNEXT]
+		} else if f.Agent != "" {
+			op = fmt.Sprintf("%s_{%s}", op, f.Agent)
+		}
+		return fmt.Sprintf("%s %s", op, f.Operands[0].latexIn(levelUnary, last))
+	}
+	if f.Terminal == sEQUAL && len(f.Args) == 2 {
+		return fmt.Sprintf("%s = %s", f.Args[0], f.Args[1])
+	}
+	if len(f.Args) > 0 {
+		return fmt.Sprintf("%s(%s)", f.Terminal, termArrayToString(f.Args))
+	}
+	return f.Terminal
+}
+
+// endsWithQuantifier checks whether the printed formula ends with a quantifier which is not enclosed in brackets
+func (f *formula) endsWithQuantifier() bool {
+	l := f.level()
+	switch l {
+	case levelQuantifier:
+		return true
+	case levelIff, levelImply, levelAnd, levelOr, levelUnary:
+		o := f.Operands[len(f.Operands)-1]
+		return o.level() >= l && o.endsWithQuantifier()
+	}
+	return false
+}
+
+// formulasLatex prints a list of formulas separated by commas
+func formulasLatex(fs []*formula) string {
+	out := make([]string, len(fs))
+	for i, f := range fs {
+		out[i] = f.latex()
+	}
+	return strings.Join(out, ", ")
+}
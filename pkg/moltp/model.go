@@ -3,9 +3,73 @@ package moltp
 import (
 	"fmt"
 	"sort"
-	"strconv"
+	"strings"
+	"time"
 )
 
+// Names of the modal systems known by the prover
+const (
+	SystemK    = "K"
+	SystemD    = "D"
+	SystemT    = "T"
+	SystemB    = "B"
+	SystemKB   = "KB"
+	SystemK4   = "K4"
+	SystemS4   = "S4"
+	SystemK5   = "K5"
+	SystemKD45 = "KD45"
+	SystemS5   = "S5"
+	SystemLTL  = "LTL"
+)
+
+// Input syntaxes of the formulas, SyntaxAuto guesses the syntax of each formula
+const (
+	SyntaxAuto    = ""
+	SyntaxTeX     = "tex"
+	SyntaxASCII   = "ascii"
+	SyntaxUnicode = "unicode"
+)
+
+// Normal forms the formulas are turned into before the search, NormalFormRenamed names the operands
+// of the modal operators and the conjunctions which would be distributed with fresh predicates
+const (
+	NormalFormNone     = ""
+	NormalFormNNF      = "nnf"
+	NormalFormCNF      = "cnf"
+	NormalFormModalCNF = "mcnf"
+	NormalFormRenamed  = "mcnf-renamed"
+)
+
+// Names of the limits reported by LimitError
+const (
+	LimitResolutions = "resolutions"
+	LimitSteps       = "steps"
+	LimitSequents    = "sequents"
+	LimitSize        = "size"
+	LimitContext     = "context"
+)
+
+var systems = map[string]Frame{
+	SystemK:    {},
+	SystemD:    {Serial: true},
+	"KD":       {Serial: true},
+	SystemT:    {Serial: true, Reflexive: true},
+	"KT":       {Serial: true, Reflexive: true},
+	SystemB:    {Serial: true, Reflexive: true, Symmetric: true},
+	SystemKB:   {Symmetric: true},
+	SystemK4:   {Transitive: true},
+	"KD4":      {Serial: true, Transitive: true},
+	SystemS4:   {Serial: true, Reflexive: true, Transitive: true},
+	SystemK5:   {Euclidean: true},
+	"KD5":      {Serial: true, Euclidean: true},
+	"K45":      {Transitive: true, Euclidean: true},
+	SystemKD45: {Serial: true, Transitive: true, Euclidean: true},
+	SystemS5:   {Serial: true, Reflexive: true, Symmetric: true, Transitive: true, Euclidean: true},
+	// LTL is S4 with a serial next relation contained in the relation of \Box, the linearity of time,
+	// the functionality of next and induction are missing, so only a sound fragment of LTL is proved
+	SystemLTL: {Serial: true, Reflexive: true, Transitive: true, Includes: []string{agentNext}},
+}
+
 type (
 	// RawFormula object holding a single unparsed formula encoded using a TEX notation
 	RawFormula struct {
@@ -13,6 +77,18 @@ type (
 		Formula string `json:"formula"`
 	}
 
+	// Problem object holding a problem read from a TPTP file, see ReadTPTP
+	// Premises hold in the world where Conjecture is to be proved. System, Quantification and Constants
+	// are the semantics stated by the logic specification of the file, they are empty when it has none.
+	// The prover uses constant domains and rigid constants
+	Problem struct {
+		Conjecture     *RawFormula
+		Premises       []*RawFormula
+		System         string
+		Quantification string
+		Constants      string
+	}
+
 	// RawSequent object holding a single unparsed Sequent
 	// Left and right parts are encoded using a TEX notation
 	RawSequent struct {
@@ -22,13 +98,75 @@ type (
 		Justification string `json:"just"`
 	}
 
+	// LimitError object holding the limit that stopped a search
+	// Err is the context error when the search was cancelled or timed out
+	LimitError struct {
+		Limit string
+		Value int
+		Err   error
+	}
+
+	// Frame object holding the properties of the accessibility relation
+	// Includes lists the agents whose relation is contained in this one, "next" is the agent of \bigcirc.
+	// No countermodel is reported for frames including other relations, see ProveOrRefute
+	Frame struct {
+		Serial     bool     `json:"serial"`
+		Reflexive  bool     `json:"reflexive"`
+		Symmetric  bool     `json:"symmetric"`
+		Transitive bool     `json:"transitive"`
+		Euclidean  bool     `json:"euclidean"`
+		Includes   []string `json:"includes,omitempty"`
+	}
+
 	// Prover object holding the prover state
+	// System selects a named modal system (see Systems), Frame selects a set of
+	// frame properties and takes precedence over System. When both are empty KD is used
+	// MaxResolutions bounds the number of resolution steps, when it is not set 1000 steps are tried
+	// MaxSteps, MaxSequents, MaxSize and Timeout bound the number of rule applications,
+	// the number of generated sequents, the total number of formula nodes in the generated
+	// sequents and the wall clock time of a search. A zero value means no bound
+	// Premises are assumed to hold in the root world, each one starts a sequent |h|_{0} <- next to <- |goal|_{0}
+	// Axioms are assumed to hold in every world, they are added to each world named during the search
+	// at most AxiomDepth steps away from the root. When AxiomDepth is not set the modal depth of the goal
+	// and the premises is used
+	// Agents and AgentFrames select the system or the frame of the relation of each agent of an indexed
+	// modality such as \Box_{a} or K_{a}, agents not listed use System and Frame.
+	// The relation of \bigcirc is serial unless it is set as the one of the agent "next"
+	// The settings are read again by each call, so the same Prover can be used with other ones
 	Prover struct {
 		Debug          bool
+		System         string
+		Frame          *Frame
+		Agents         map[string]string
+		AgentFrames    map[string]*Frame
+		Native         bool   // keep And, Or, Iff, Diamond and Exists and use their own rules
+		Syntax         string // syntax of the formulas, one of the Syntax constants
+		NormalForm     string // normal form of the formulas before the search, one of the NormalForm constants
+		Premises       []*RawFormula
+		Axioms         []*RawFormula
+		AxiomDepth     int
+		MaxResolutions int
+		MaxSteps       int
+		MaxSequents    int
+		MaxSize        int
+		Timeout        time.Duration
 		Rules          []inferenceRule
 		ResolutionRule resolutionRule
 		R              *relation
 		worldsKeeper   *worldskeeper
+		sequents       map[string]*Sequent // all the sequents of the last search
+	}
+
+	// Countermodel object holding a Kripke model where a formula is false
+	// Worlds are named after the world indexes used by the prover, Relation holds the worlds
+	// accessible from each world and Valuation the atoms true in each world
+	// Agents holds the relation of each agent of the indexed modalities
+	Countermodel struct {
+		Root      string                         `json:"root"`
+		Worlds    []string                       `json:"worlds"`
+		Relation  map[string][]string            `json:"relation"`
+		Agents    map[string]map[string][]string `json:"agents,omitempty"`
+		Valuation map[string][]string            `json:"valuation"`
 	}
 
 	// Sequent object holding a Sequent
@@ -37,51 +175,187 @@ type (
 		Justification []string
 		Left          []*formula
 		Right         []*formula
+		principal     *formula     // the formula the rule was applied to
+		complement    *formula     // the literal of the second premise the resolution rule resolved the principal with
+		unification   *unification // the unification used by the resolution rule
+	}
+
+	// Proof object holding a derivation as a graph of steps
+	// Root is the step holding the initial sequent, Goal the one holding the empty sequent
+	// Goal is nil when no solution was found. Steps are listed so that premises come before their conclusions
+	// Settings are the ones of the Prover which found the proof
+	Proof struct {
+		Root     *ProofStep
+		Goal     *ProofStep
+		Steps    []*ProofStep
+		Settings *ProofSettings
+	}
+
+	// ProofSettings object holding the settings of a Prover which decide what a proof derives from,
+	// see Prover for their meaning
+	ProofSettings struct {
+		System      string            `json:"system,omitempty"`
+		Frame       *Frame            `json:"frame,omitempty"`
+		Agents      map[string]string `json:"agents,omitempty"`
+		AgentFrames map[string]*Frame `json:"agentFrames,omitempty"`
+		Native      bool              `json:"native,omitempty"`
+		Syntax      string            `json:"syntax,omitempty"`
+		NormalForm  string            `json:"normalForm,omitempty"`
+		Premises    []*RawFormula     `json:"premises,omitempty"`
+		Axioms      []*RawFormula     `json:"axioms,omitempty"`
+		AxiomDepth  int               `json:"axiomDepth,omitempty"`
+	}
+
+	// ProofStep object holding a sequent together with the rule, the premises and the principal formula used to derive it
+	// For the resolution rule Principal is the literal of the first premise and Complement the literal of the second one
+	// it was resolved with, a factor records the two literals of its premise it made equal. Substitution maps variables to terms and Worlds maps world variables to the world indexes they are bound to
+	ProofStep struct {
+		ID           string
+		Sequent      *Sequent
+		Rule         string
+		Premises     []*ProofStep
+		Principal    string
+		Complement   string
+		Substitution map[string]string
+		Worlds       map[string]string
+	}
+
+	// problem holds the parsed goal, premises and axioms of a search
+	// Depth is the modal depth of the goal and the premises before they were renamed, 0 when they were not
+	problem struct {
+		Goal     *formula
+		Premises []*formula
+		Axioms   []*formula
+		Depth    int
+	}
+
+	// normalizer turns formulas into clausal normal forms, Modal normalizes the operands of the
+	// modal operators and of the quantifiers too, Rename names subformulas with fresh predicates
+	// whose definitions are collected in Definitions
+	normalizer struct {
+		Modal       bool
+		Rename      bool
+		Used        map[string]bool // names of the predicates which cannot be used for definitions
+		Next        int
+		Definitions []*formula
+	}
+
+	// ProofError object holding the first step of a proof which cannot be derived
+	ProofError struct {
+		Step   string
+		Reason string
+	}
+
+	// ParseError object holding a syntax error of a formula
+	// Offset and End are the character offsets of the beginning and of the end of the problem,
+	// Line and Column, counted from 1, are the position of its beginning and Source is the line holding it.
+	// Expected tells what was expected and Found is the text found instead
+	ParseError struct {
+		Offset   int    `json:"offset"`
+		End      int    `json:"end"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+		Expected string `json:"expected,omitempty"`
+		Found    string `json:"found"`
+		Reason   string `json:"reason"`
+		Source   string `json:"source"`
+		from, to int    // byte offsets of the problem, set while parsing
+	}
+
+	// Formula object holding a formula returned by Parse, it cannot be changed
+	Formula struct {
+		f *formula
+	}
+
+	// Visitor is called by Walk on a formula, its operands are visited by the returned Visitor unless it is nil
+	Visitor interface {
+		Visit(f *Formula) Visitor
 	}
 
 	token struct {
-		Value string   // token symbol value
-		Vars  []string // variable, used for functions and unviversal ops
-		IsTe  bool     // is terminal
-		IsIn  bool     // is an index for a terminal
-		IsLB  bool     // is left braket
-		IsRB  bool     // is right braket
-		IsOp  bool     // is operator
-		UnOp  bool     // is unary operator
-		BiOp  bool     // is binary oprator
-		MuOp  bool     // is miltiple arguments operator
-		IsCo  bool     // is comma for multiple args operators
-		Skip  int      // how many char was have to be skipped from input
+		Value  string  // token symbol value
+		Args   []*term // arguments, used for predicates
+		IsTe   bool    // is terminal
+		IsIn   bool    // is an index for a terminal
+		IsLB   bool    // is left braket
+		IsRB   bool    // is right braket
+		IsOp   bool    // is operator
+		UnOp   bool    // is unary operator
+		BiOp   bool    // is binary oprator
+		MuOp   bool    // is miltiple arguments operator
+		IsCo   bool    // is comma for multiple args operators
+		Agent  string  // agent of an indexed modal operator
+		Skip   int     // how many char was have to be skipped from input
+		Offset int     // how many char were read before the token
 	}
 
 	unification struct {
-		Map map[string]string
+		Map   map[string]*term
+		Paths map[string][]*worldsymbol // full world index of the symbol bound to a world variable
+	}
+
+	// term object holding an argument of a predicate
+	// it can be a variable, a constant or a function application
+	term struct {
+		Value string  `json:"value"`
+		Args  []*term `json:"args,omitempty"`
+		IsVar bool    `json:"var,omitempty"`
 	}
 
+	// relation holds the frame of the default modality and the frames of the agents
 	relation struct {
-		Serial bool
+		Frame
+		Agents map[string]Frame
+	}
+
+	// wsolver holds the worlds named by a set of world indexes
+	// it is used to unify world indexes according to the relation properties
+	wsolver struct {
+		R         *relation
+		symbols   map[string]*worldsymbol
+		parents   map[string]string
+		order     []string
+		instances bool            // variables are only instantiated, two of them can be bound although they might denote no world
+		reaching  map[string]bool // the bindings reachFrom is looking for
 	}
 
 	worldsymbol struct {
-		Value  string
-		Ground bool
+		Value  string  `json:"value"`
+		Ground bool    `json:"ground,omitempty"`
+		Args   []*term `json:"args,omitempty"`  // arguments of skolem functions
+		Agent  string  `json:"agent,omitempty"` // agent of the modality the world was reached by
 	}
 
 	worldindex struct {
-		Symbols []*worldsymbol
+		Symbols []*worldsymbol `json:"symbols,omitempty"`
 	}
 
 	worldskeeper struct {
 		nextConst    int
 		nextVar      string
 		nextFunction string
+		worlds       map[string]*worldsymbol // new worlds by the formula they were introduced for
+		terms        map[string][]*term      // new terms by the formula they were introduced for
 	}
 
 	formula struct {
-		Operands []*formula
-		Terminal string
-		Index    worldindex
-		Vars     []string
+		Operands []*formula    `json:"operands,omitempty"`
+		Terminal string        `json:"terminal"`
+		Index    worldindex    `json:"index"`
+		Vars     []string      `json:"vars,omitempty"`  // variables names of quantifiers
+		Args     []*term       `json:"args,omitempty"`  // arguments of predicates
+		Agent    string        `json:"agent,omitempty"` // agent of indexed modal operators
+		table    *formulaTable // table the formula was interned by, nil when it was not
+		id       int           // identifier of the formula in its table
+		nodes    int           // size of an interned formula
+	}
+
+	// formulaTable hash-conses formulas: structurally equal formulas interned by the same table
+	// are the same node, so that they are compared by pointer and hashed by their id.
+	// Interned formulas are shared by the sequents of a search and are never changed
+	formulaTable struct {
+		nodes map[string]*formula // interned formulas by their key
+		byID  []*formula          // interned formulas by their id, ids start from 1
 	}
 )
 
@@ -93,30 +367,115 @@ func (s *Sequent) String() string {
 		s.Justification)
 }
 
+// key returns the sequent without name and justification, used to find duplicates
+func (s *Sequent) key() string {
+	return fmt.Sprintf("%s <- %s", formulaArrayToString(s.Left), formulaArrayToString(s.Right))
+}
+
+// modalDepth returns the maximum number of nested modal operators
+func (f *formula) modalDepth() int {
+	out := 0
+	for _, o := range f.Operands {
+		if d := o.modalDepth(); d > out {
+			out = d
+		}
+	}
+	if f.is(sBOX) || f.is(sDIAMOND) {
+		out = out + 1
+	}
+	return out
+}
+
+// is checks that f is a formula built by the operator op, atoms can be named after an operator
+func (f *formula) is(op string) bool {
+	return f.Terminal == op && len(f.Operands) > 0
+}
+
+// size returns the number of formula nodes in the sequent
+func (s *Sequent) size() int {
+	out := 0
+	for _, f := range append(append([]*formula{}, s.Left...), s.Right...) {
+		out = out + f.size()
+	}
+	return out
+}
+
+func (f *formula) size() int {
+	if f.table != nil && f.table.owns(f) {
+		return f.nodes
+	}
+	out := 1
+	for _, o := range f.Operands {
+		out = out + o.size()
+	}
+	return out
+}
+
+func (e *LimitError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("No solution found: search stopped, %s", e.Err)
+	}
+	return fmt.Sprintf("No solution found: %s limit of %d reached", e.Limit, e.Value)
+}
+
+// Unwrap returns the context error, if any
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// sortFormulas moves the atomic formulas at the beginning of the left side and at the end of the right one
+// so that rules, which look at the last left and first right formulas, find the formulas still to be reduced
+func (s *Sequent) sortFormulas() {
+	left := []*formula{}
+	right := []*formula{}
+	for _, f := range s.Left {
+		if len(f.Operands) == 0 {
+			left = append(left, f)
+		}
+	}
+	for _, f := range s.Left {
+		if len(f.Operands) > 0 {
+			left = append(left, f)
+		}
+	}
+	for _, f := range s.Right {
+		if len(f.Operands) > 0 {
+			right = append(right, f)
+		}
+	}
+	for _, f := range s.Right {
+		if len(f.Operands) == 0 {
+			right = append(right, f)
+		}
+	}
+	s.Left = left
+	s.Right = right
+}
+
 func (f *formula) String() string {
 	switch len(f.Operands) {
 	case 0:
 		ter := f.Terminal
-		if len(f.Vars) > 0 {
-			vars := ""
-			for _, v := range f.Vars {
-				if vars == "" {
-					vars = v
-				} else {
-					vars = fmt.Sprintf("%s,%s", vars, v)
-				}
-			}
-			ter = fmt.Sprintf("%s(%s)", ter, vars)
+		if f.Terminal == sEQUAL && len(f.Args) == 2 {
+			ter = fmt.Sprintf("%s = %s", f.Args[0], f.Args[1])
+		} else if len(f.Args) > 0 {
+			ter = fmt.Sprintf("%s(%s)", ter, termArrayToString(f.Args))
 		}
 		if len(f.Index.Symbols) < 1 {
 			return fmt.Sprintf("%s", ter)
 		}
 		return fmt.Sprintf("|%s|_{%s}", ter, &f.Index)
 	case 1:
+		op := f.Terminal
+		if f.Terminal == sBOX && f.Agent == agentNext {
+			op = sNEXT
+		} else if f.Agent != "" {
+			op = fmt.Sprintf("%s_{%s}", op, f.Agent)
+		}
 		if len(f.Index.Symbols) < 1 {
-			return fmt.Sprintf("( %s %s )", f.Terminal, f.Operands[0])
+			return fmt.Sprintf("( %s %s )", op, f.Operands[0])
 		}
-		return fmt.Sprintf("|( %s %s )|_{%s}", f.Terminal, f.Operands[0], &f.Index)
+		return fmt.Sprintf("|( %s %s )|_{%s}", op, f.Operands[0], &f.Index)
 	case 2:
 		// Workaround when multioperators have 2 arguments
 		// TODO: Find a better way to handle this case
@@ -149,19 +508,54 @@ func (f *formula) String() string {
 }
 
 func (s *worldsymbol) String() string {
+	if len(s.Args) > 0 {
+		return fmt.Sprintf("%s(%s)", s.Value, termArrayToString(s.Args))
+	}
 	return s.Value
 }
 
+func (t *term) String() string {
+	if len(t.Args) > 0 {
+		return fmt.Sprintf("%s(%s)", t.Value, termArrayToString(t.Args))
+	}
+	return t.Value
+}
+
+func termArrayToString(a []*term) string {
+	out := ""
+	for _, t := range a {
+		if out == "" {
+			out = fmt.Sprintf("%s", t)
+		} else {
+			out = fmt.Sprintf("%s,%s", out, t)
+		}
+	}
+	return out
+}
+
+// term returns the world symbol as a term, so that it can be used as argument of a skolem function
+func (s *worldsymbol) term() *term {
+	return &term{Value: s.Value, Args: s.Args, IsVar: !s.Ground}
+}
+
+// label returns the symbol followed by the agent of the modality the world was reached by
+func (s *worldsymbol) label() string {
+	if s.Agent != "" {
+		return fmt.Sprintf("%s^{%s}", s, s.Agent)
+	}
+	return s.String()
+}
+
 func (i *worldindex) String() string {
 	switch len(i.Symbols) {
 	case 0:
 		return ""
 	case 1:
-		return fmt.Sprintf("%s", i.Symbols[0])
+		return i.Symbols[0].label()
 	default:
-		out := fmt.Sprintf("%s", i.Symbols[0])
+		out := i.Symbols[0].label()
 		for _, k := range i.Symbols[1:] {
-			out = fmt.Sprintf("%s:%s", out, k)
+			out = fmt.Sprintf("%s:%s", out, k.label())
 		}
 		return out
 	}
@@ -189,6 +583,7 @@ func (u *unification) String() string {
 	return fmt.Sprintf("{%s}", out)
 }
 
+// compose returns the unification obtained applying n first and m after
 func compose(m, n *unification) *unification {
 	if m == nil {
 		return n
@@ -196,57 +591,170 @@ func compose(m, n *unification) *unification {
 	if n == nil {
 		return m
 	}
+	o := &unification{Map: make(map[string]*term), Paths: make(map[string][]*worldsymbol)}
+	for k, v := range n.Map {
+		o.Map[k] = m.applyToTerm(v)
+	}
+	for k, v := range n.Paths {
+		o.Paths[k] = m.applyToSymbols(v)
+	}
 	for k, v := range m.Map {
-		n.Map[k] = v
+		if _, ok := o.Map[k]; !ok {
+			o.Map[k] = v
+		}
 	}
-	return n
+	for k, v := range m.Paths {
+		if _, ok := o.Paths[k]; !ok {
+			o.Paths[k] = v
+		}
+	}
+	return o
 }
 
-func unify(f, g *formula) *unification {
-	u := &unification{Map: make(map[string]string)}
-	l1 := len(f.Vars)
-	l2 := len(g.Vars)
-	if l1 < 1 || l2 < 1 {
-		return u
+func (t *term) occurs(v string) bool {
+	if t.IsVar {
+		return t.Value == v
+	}
+	for _, a := range t.Args {
+		if a.occurs(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// walk follows the bindings of the variable t
+func (u *unification) walk(t *term) *term {
+	for t.IsVar {
+		n, ok := u.Map[t.Value]
+		if !ok {
+			break
+		}
+		t = n
+	}
+	return t
+}
+
+// unifyTerm extends u so that a and b become equal, it returns false if there is a clash
+// or if a variable should be bound to a term containing it
+func (u *unification) unifyTerm(a, b *term) bool {
+	a = u.walk(a)
+	b = u.walk(b)
+	switch {
+	case a.IsVar && b.IsVar && a.Value == b.Value:
+		return true
+	case a.IsVar:
+		if u.applyToTerm(b).occurs(a.Value) {
+			return false
+		}
+		u.Map[a.Value] = b
+		return true
+	case b.IsVar:
+		if u.applyToTerm(a).occurs(b.Value) {
+			return false
+		}
+		u.Map[b.Value] = a
+		return true
 	}
-	l := l1
-	if l2 < l1 {
-		l = l2
+	if a.Value != b.Value || len(a.Args) != len(b.Args) {
+		return false
 	}
-	// TODO: change this
-	// In short we need to substite all non free variables usually not numbers
-	// with numbers
-	for i := 0; i < l; i++ {
-		a := f.Vars[i]
-		_, err1 := strconv.Atoi(a)
-		b := g.Vars[i]
-		_, err2 := strconv.Atoi(b)
-		if err1 == nil && err2 != nil {
-			u.Map[b] = a
+	for i := range a.Args {
+		if !u.unifyTerm(a.Args[i], b.Args[i]) {
+			return false
 		}
-		if err1 != nil && err2 == nil {
-			u.Map[a] = b
+	}
+	return true
+}
+
+// unify returns the most general unification of two lists of arguments, nil if they do not unify
+// Robinson algorithm with occurs check
+func unify(a, b []*term) *unification {
+	if len(a) != len(b) {
+		return nil
+	}
+	u := &unification{Map: make(map[string]*term)}
+	for i := range a {
+		if !u.unifyTerm(a[i], b[i]) {
+			return nil
 		}
 	}
-	return u
+	// Make the unification idempotent
+	o := &unification{Map: make(map[string]*term)}
+	for k, v := range u.Map {
+		o.Map[k] = u.applyToTerm(v)
+	}
+	return o
 }
 
+// munify unifies two atomic formulas: first their world indexes and then their arguments
+// world variables can only be bound by the world indexes unification
 func (R *relation) munify(f, g *formula) *unification {
-	m := unify(f, g)
-	n := R.wunify(&f.Index, &g.Index)
+	return R.unifyAtoms(f, g, true)
+}
+
+// unifyAtoms works like munify, when exists is false the world variables may be bound to worlds
+// which might not exist, that is the atoms are only made equal by an instance of their variables
+func (R *relation) unifyAtoms(f, g *formula, exists bool) *unification {
+	if f.Terminal != g.Terminal || len(f.Args) != len(g.Args) {
+		return nil
+	}
+	n := R.wunifyAt(&f.Index, &g.Index, exists)
+	if n == nil {
+		return nil
+	}
+	m := unify(n.applyToTerms(f.Args), n.applyToTerms(g.Args))
+	if m == nil || m.bindsWorlds(&f.Index, &g.Index) {
+		return nil
+	}
 	return compose(m, n)
 }
 
-func (i *worldindex) parent(s *worldsymbol) *worldsymbol {
-	for k, p := range i.Symbols {
-		if p == s {
-			if k < len(i.Symbols)+1 {
-				return i.Symbols[k+1]
+// anchor returns the unification that makes an equality holding at the world index i usable at the world index j.
+// Terms are rigid designators, so that an equality holding in a world holds in every world, but i must denote a world:
+// when some world variable of i might denote no world, i is unified with j as munify does
+func (R *relation) anchor(i, j *worldindex) *unification {
+	if len(i.Symbols) == 0 || newWSolver(R, i).exists(make(map[string]string)) {
+		return &unification{Map: make(map[string]*term)}
+	}
+	return R.wunify(i, j)
+}
+
+// bindsWorlds checks whether u binds some world variable of the world indexes
+func (u *unification) bindsWorlds(indexes ...*worldindex) bool {
+	for _, i := range indexes {
+		for _, w := range i.Symbols {
+			if _, ok := u.Map[w.Value]; ok && !w.Ground {
+				return true
 			}
-			return nil
 		}
 	}
-	return nil
+	return false
+}
+
+// subterms calls fn on every subterm of ts which is not a variable together with its position
+// a position lists the argument followed at each level
+func subterms(ts []*term, pos []int, fn func(pos []int, t *term)) {
+	for i, t := range ts {
+		if t.IsVar {
+			continue
+		}
+		p := append(append([]int{}, pos...), i)
+		fn(p, t)
+		subterms(t.Args, p, fn)
+	}
+}
+
+// replaceTerm returns a copy of ts with the subterm at the position pos replaced by r
+func replaceTerm(ts []*term, pos []int, r *term) []*term {
+	out := append([]*term{}, ts...)
+	if len(pos) == 1 {
+		out[pos[0]] = r
+		return out
+	}
+	t := ts[pos[0]]
+	out[pos[0]] = &term{Value: t.Value, IsVar: t.IsVar, Args: replaceTerm(t.Args, pos[1:], r)}
+	return out
 }
 
 func (i *worldindex) parentIndex(s *worldsymbol) []*worldsymbol {
@@ -282,126 +790,663 @@ func start(i *worldindex) *worldsymbol {
 	return i.Symbols[l-1]
 }
 
-func (p *Prover) initProver() {
+func (p *Prover) initProver() error {
+	switch p.Syntax {
+	case SyntaxAuto, SyntaxTeX, SyntaxASCII, SyntaxUnicode:
+	default:
+		return fmt.Errorf("unknown syntax %s", p.Syntax)
+	}
+	switch p.NormalForm {
+	case NormalFormNone, NormalFormNNF, NormalFormCNF, NormalFormModalCNF, NormalFormRenamed:
+	default:
+		return fmt.Errorf("unknown normal form %s", p.NormalForm)
+	}
+	// The relation and the rules are built again from the current settings, so that a Prover
+	// can be given another system, frame or agents between two calls
+	r, err := p.newRelation()
+	if err != nil {
+		return err
+	}
 	if p.R == nil {
-		p.R = &relation{Serial: true}
+		p.R = r
+	} else {
+		*p.R = *r
 	}
 	if p.worldsKeeper == nil {
 		p.worldsKeeper = &worldskeeper{nextVar: "w", nextConst: 0, nextFunction: "f"}
 	}
-	if len(p.Rules) == 0 {
-		// TODO make this look better
-		p.Rules = []inferenceRule{
-			r2{Name: "R2"},
-			r3{Name: "R3"},
-			r4{Name: "R4"},
-			r5{Name: "R5"},
-			r6{Name: "R6"},
-			r7{Name: "R7", worldsKeeper: p.worldsKeeper},
-			r8{Name: "R8", worldsKeeper: p.worldsKeeper},
-			r9{Name: "R9", worldsKeeper: p.worldsKeeper},
-			r10{Name: "R10", worldsKeeper: p.worldsKeeper},
-		}
+	// TODO make this look better
+	p.Rules = []inferenceRule{
+		r2{Name: "R2"},
+		r3{Name: "R3"},
+		r4{Name: "R4"},
+		r5{Name: "R5"},
+		r6{Name: "R6"},
+		r7{Name: "R7", worldsKeeper: p.worldsKeeper},
+		r8{Name: "R8", worldsKeeper: p.worldsKeeper},
+		r9{Name: "R9", worldsKeeper: p.worldsKeeper},
+		r10{Name: "R10", worldsKeeper: p.worldsKeeper},
 	}
-	if p.ResolutionRule == nil {
-		p.ResolutionRule = r1{Name: "R1", R: p.R}
+	if p.Native {
+		p.Rules = append(p.Rules,
+			r11{Name: "R11"},
+			r12{Name: "R12"},
+			r13{Name: "R13"},
+			r14{Name: "R14"},
+			r15{Name: "R15"},
+			r16{Name: "R16"},
+			r17{Name: "R17"},
+			r18{Name: "R18"},
+			r19{Name: "R19"},
+			r20{Name: "R20"},
+			r21{Name: "R21", worldsKeeper: p.worldsKeeper},
+			r22{Name: "R22", worldsKeeper: p.worldsKeeper},
+			r23{Name: "R23", worldsKeeper: p.worldsKeeper},
+			r24{Name: "R24", worldsKeeper: p.worldsKeeper},
+		)
 	}
+	p.ResolutionRule = r1{Name: "R1", R: p.R}
+	return nil
 }
 
-func (u *unification) applyUnification(f *formula) *formula {
-	if f.Terminal == sFORALL {
-		f.Operands[len(f.Operands)-1] = u.applyUnification(f.Operands[len(f.Operands)-1])
-	} else {
-		for i, o := range f.Operands {
-			f.Operands[i] = u.applyUnification(o)
+// newRelation returns the relation of the system or the frame of the prover and of the agents
+func (p *Prover) newRelation() (*relation, error) {
+	f := p.Frame
+	if f == nil {
+		name := p.System
+		if name == "" {
+			name = SystemD
 		}
+		var err error
+		f, err = SystemFrame(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := &relation{Frame: *f, Agents: make(map[string]Frame)}
+	for a, name := range p.Agents {
+		g, err := SystemFrame(name)
+		if err != nil {
+			return nil, fmt.Errorf("Agent %s: %s", a, err)
+		}
+		r.Agents[a] = *g
+	}
+	for a, g := range p.AgentFrames {
+		r.Agents[a] = *g
+	}
+	if _, ok := r.Agents[agentNext]; !ok {
+		r.Agents[agentNext] = Frame{Serial: true}
+	}
+	return r, nil
+}
+
+// SystemFrame returns the frame properties of a named modal system
+func SystemFrame(name string) (*Frame, error) {
+	f, ok := systems[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown modal system %s", name)
+	}
+	return &f, nil
+}
+
+// Systems returns the names of the known modal systems
+func Systems() []string {
+	names := []string{}
+	for k := range systems {
+		names = append(names, k)
 	}
+	sort.Strings(names)
+	return names
+}
 
+func (u *unification) applyUnification(f *formula) *formula {
+	v := u
+	if f.Terminal == sFORALL || f.Terminal == sEXISTS {
+		// Variables bound by the quantifier are not free in its scope
+		v = u.without(f.Vars)
+	}
 	t := copyTopFormulaLevel(f)
 	changes := false
-	if len(t.Operands) == 0 {
-		n, ok := u.Map[t.Terminal]
-		if ok {
+	for i, o := range t.Operands {
+		// In multi operator formulas the first operands are the variables names
+		if (f.Terminal == sFORALL || f.Terminal == sEXISTS) && i < len(t.Operands)-1 {
+			continue
+		}
+		t.Operands[i] = v.applyUnification(o)
+		if t.Operands[i] != o {
 			changes = true
-			t.Terminal = n
 		}
-		for i, v := range t.Vars {
-			n, ok := u.Map[v]
-			if ok {
-				changes = true
-				t.Vars[i] = n
-			}
+	}
+	for i, a := range t.Args {
+		t.Args[i] = v.applyToTerm(a)
+		if t.Args[i] != a {
+			changes = true
 		}
 	}
+	if index, ok := u.applyToIndex(&f.Index); ok {
+		changes = true
+		t.Index = *index
+	}
 	if !changes {
 		return f
 	}
 	return t
 }
 
+// without returns a copy of u without the bindings of the given variables
+func (u *unification) without(vars []string) *unification {
+	found := false
+	for _, k := range vars {
+		if _, ok := u.Map[k]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return u
+	}
+	n := &unification{Map: make(map[string]*term), Paths: u.Paths}
+	for k, v := range u.Map {
+		n.Map[k] = v
+	}
+	for _, k := range vars {
+		delete(n.Map, k)
+	}
+	return n
+}
+
+// applyToTerm returns t with all the bound variables replaced, t itself if nothing changed
+func (u *unification) applyToTerm(t *term) *term {
+	if t.IsVar {
+		n, ok := u.Map[t.Value]
+		if !ok || n == t {
+			return t
+		}
+		if n.occurs(t.Value) {
+			// This should never happen thanks to the occurs check
+			return n
+		}
+		return u.applyToTerm(n)
+	}
+	args := u.applyToTerms(t.Args)
+	for i := range args {
+		if args[i] != t.Args[i] {
+			return &term{Value: t.Value, Args: args}
+		}
+	}
+	return t
+}
+
+func (u *unification) applyToTerms(ts []*term) []*term {
+	out := make([]*term, len(ts))
+	for i, t := range ts {
+		out[i] = u.applyToTerm(t)
+	}
+	return out
+}
+
+func (u *unification) applyToSymbols(ws []*worldsymbol) []*worldsymbol {
+	out := make([]*worldsymbol, len(ws))
+	for i, w := range ws {
+		out[i] = w
+		if len(w.Args) > 0 {
+			args := u.applyToTerms(w.Args)
+			for k := range args {
+				if args[k] != w.Args[k] {
+					out[i] = &worldsymbol{Value: w.Value, Ground: w.Ground, Args: args, Agent: w.Agent}
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// applyToIndex replaces every bound world variable and all its parents with
+// the world index of the symbol it is bound to
+func (u *unification) applyToIndex(i *worldindex) (*worldindex, bool) {
+	symbols := i.Symbols
+	changes := false
+	for k := 0; k < len(symbols); k++ {
+		if symbols[k].Ground {
+			continue
+		}
+		p, ok := u.Paths[symbols[k].Value]
+		if !ok {
+			continue
+		}
+		changes = true
+		symbols = append(append([]*worldsymbol{}, symbols[:k]...), p...)
+	}
+	n := u.applyToSymbols(symbols)
+	for k := range n {
+		if n[k] != symbols[k] {
+			changes = true
+		}
+	}
+	if !changes {
+		return i, false
+	}
+	return &worldindex{Symbols: n}, true
+}
+
 func (u *unification) applyUnifications(fs []*formula) []*formula {
+	out := make([]*formula, len(fs))
 	for i, f := range fs {
-		fs[i] = u.applyUnification(f)
+		out[i] = u.applyUnification(f)
 	}
-	return fs
+	return out
 }
 
-func (R *relation) findUnification(s0, s1 *worldsymbol) *unification {
-	u := &unification{Map: make(map[string]string)}
-	// TODO: we need to change this
-	_, err := strconv.Atoi(s1.Value)
-	if err == nil {
-		u.Map[s0.Value] = s1.Value
-		return u
+func newWSolver(R *relation, indexes ...*worldindex) *wsolver {
+	s := &wsolver{R: R, symbols: make(map[string]*worldsymbol), parents: make(map[string]string)}
+	for _, i := range indexes {
+		for k := len(i.Symbols) - 1; k >= 0; k-- {
+			v := i.Symbols[k].String()
+			if _, ok := s.symbols[v]; ok {
+				continue
+			}
+			s.symbols[v] = i.Symbols[k]
+			s.order = append(s.order, v)
+			if k < len(i.Symbols)-1 {
+				s.parents[v] = i.Symbols[k+1].String()
+			}
+		}
 	}
-	_, err = strconv.Atoi(s0.Value)
-	if err != nil {
-		return nil
+	return s
+}
+
+func (s *wsolver) isVar(v string) bool {
+	w, ok := s.symbols[v]
+	return ok && !w.Ground
+}
+
+// resolve follows the bindings b starting from v
+func (s *wsolver) resolve(b map[string]string, v string) string {
+	for n := 0; n <= len(b); n++ {
+		t, ok := b[v]
+		if !ok || !s.isVar(v) {
+			return v
+		}
+		v = t
 	}
-	u.Map[s1.Value] = s0.Value
-	return u
+	return v
 }
 
-func (R *relation) wunify(i, j *worldindex) *unification {
-	if start(i).Value == "0" && start(j).Value == "0" {
-		if end(i).Ground && end(j).Ground && end(i).Value == end(j).Value {
-			return &unification{Map: make(map[string]string)}
-		}
-		if (end(i).Ground && !end(j).Ground || !end(i).Ground && end(j).Ground) && R.Serial {
-			o := R.findUnification(end(i), end(j))
-			if o != nil {
-				return o
+func (s *wsolver) parent(b map[string]string, v string) (string, bool) {
+	p, ok := s.parents[v]
+	if !ok {
+		return "", false
+	}
+	return s.resolve(b, p), true
+}
+
+// ancestors returns v and all the worlds it descends from, v first
+func (s *wsolver) ancestors(b map[string]string, v string) []string {
+	out := []string{v}
+	for len(out) <= len(s.order) {
+		p, ok := s.parent(b, out[len(out)-1])
+		if !ok {
+			break
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// frame returns the frame of the relation of the agent
+func (R *relation) frame(agent string) *Frame {
+	if f, ok := R.Agents[agent]; ok && agent != "" {
+		return &f
+	}
+	return &R.Frame
+}
+
+// includes checks that the relation of the agent is contained in the relation of the frame
+func (fr *Frame) includes(agent string) bool {
+	for _, a := range fr.Includes {
+		if a == agent {
+			return true
+		}
+	}
+	return false
+}
+
+// includes checks that a frame of the relation includes the relation of another agent
+func (R *relation) includes() bool {
+	if len(R.Frame.Includes) > 0 {
+		return true
+	}
+	for _, fr := range R.Agents {
+		if len(fr.Includes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// agent returns the agent of the modality the world v was reached by
+func (s *wsolver) agent(v string) string {
+	if w, ok := s.symbols[v]; ok {
+		return w.Agent
+	}
+	return ""
+}
+
+// accessible computes the smallest relation of the agent that contains the edges
+// between the worlds not bound by b reached by the agent, or by an agent its frame includes,
+// and has the properties of its frame
+func (s *wsolver) accessible(b map[string]string, agent string) map[string]map[string]bool {
+	fr := s.R.frame(agent)
+	a := make(map[string]map[string]bool)
+	nodes := []string{}
+	for _, v := range s.order {
+		if s.resolve(b, v) == v {
+			nodes = append(nodes, v)
+			a[v] = make(map[string]bool)
+		}
+	}
+	for _, v := range nodes {
+		if p, ok := s.parent(b, v); ok && (s.agent(v) == agent || fr.includes(s.agent(v))) {
+			a[p][v] = true
+		}
+	}
+	fr.close(nodes, a)
+	return a
+}
+
+// close adds to a all the pairs of nodes required by the frame properties
+func (fr *Frame) close(nodes []string, a map[string]map[string]bool) {
+	for changes := true; changes; {
+		changes = false
+		add := func(x, y string) {
+			if !a[x][y] {
+				a[x][y] = true
+				changes = true
 			}
 		}
-		if !end(i).Ground && !end(j).Ground && R.Serial {
-			o1 := R.findUnification(j.parent(end(j)), end(i))
-			m := &worldsymbol{}
-			m.Value = i.parent(end(i)).Value
-			if o1 != nil {
-				m.Value = o1.Map[end(i).Value]
+		for _, x := range nodes {
+			if fr.Reflexive {
+				add(x, x)
 			}
-			o2 := R.findUnification(m, end(j))
-			if o1 != nil && o2 != nil {
-				return compose(o1, o2)
+			for _, y := range nodes {
+				if !a[x][y] {
+					continue
+				}
+				if fr.Symmetric {
+					add(y, x)
+				}
+				for _, z := range nodes {
+					if fr.Transitive && a[y][z] {
+						add(x, z)
+					}
+					if fr.Euclidean && a[x][z] {
+						add(y, z)
+					}
+				}
 			}
-			if o1 != nil {
-				return o1
+		}
+	}
+}
+
+func (fr *Frame) serial() bool {
+	return fr.Serial || fr.Reflexive
+}
+
+// admissible checks that the world variable v bound by b is accessible from its parent
+// by the relation of the agent v was reached by
+func (s *wsolver) admissible(b map[string]string, v string) bool {
+	t := s.resolve(b, v)
+	pv, ok := s.parent(b, v)
+	if !ok {
+		return false
+	}
+	agent := s.agent(v)
+	a := s.accessible(b, agent)
+	if !s.isVar(t) {
+		return a[pv][t]
+	}
+	// Two variables can denote the same world only if t was reached by the agent of v,
+	// or by one whose relation is included, and their parents must have a common accessible world
+	f := s.R.frame(agent)
+	pt, ok := s.parent(b, t)
+	if !ok || !(f.serial() || s.instances) || s.agent(t) != agent && !(f.includes(s.agent(t)) && (s.R.frame(s.agent(t)).serial() || s.instances)) {
+		return false
+	}
+	if pv == pt {
+		return true
+	}
+	if f.Reflexive || f.Transitive || f.Euclidean {
+		return a[pv][pt] || a[pt][pv]
+	}
+	return false
+}
+
+// exists checks that every world variable left unbound by b denotes at least a world
+func (s *wsolver) exists(b map[string]string) bool {
+	for _, v := range s.order {
+		if !s.isVar(v) || s.resolve(b, v) != v || s.R.frame(s.agent(v)).serial() {
+			continue
+		}
+		a := s.accessible(b, s.agent(v))
+		p, _ := s.parent(b, v)
+		found := false
+		for w := range a[p] {
+			if s.known(b, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// known checks that w is a world which exists, neither w nor its ancestors are variables:
+// a skolem function of a variable is reached from a world which might not exist
+func (s *wsolver) known(b map[string]string, w string) bool {
+	for _, k := range s.ancestors(b, w) {
+		if s.isVar(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the ground worlds of the solver, starting from the ancestors of the given worlds
+func (s *wsolver) candidates(b map[string]string, vs ...string) []string {
+	out := []string{}
+	seen := make(map[string]bool)
+	for _, v := range vs {
+		for _, k := range s.ancestors(b, v) {
+			if !seen[k] && !s.isVar(k) {
+				seen[k] = true
+				out = append(out, k)
+			}
+		}
+	}
+	for k := len(s.order) - 1; k >= 0; k-- {
+		v := s.order[k]
+		if !seen[v] && !s.isVar(v) {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// findUnification binds the world variable s0 to the world s1
+// if the relation does not allow it, it tries to move the parent of s0, or to bind the ancestors of s1
+// when the one of s0 is a known world, so that s1 becomes accessible
+func (R *relation) findUnification(s *wsolver, b map[string]string, s0, s1 string) map[string]string {
+	for _, k := range s.ancestors(b, s1) {
+		if k == s0 {
+			// s0 would be one of its own parents
+			return nil
+		}
+	}
+	n := with(b, s0, s1)
+	if s.admissible(n, s0) {
+		return n
+	}
+	p0, ok := s.parent(b, s0)
+	if !ok {
+		return nil
+	}
+	if !s.isVar(p0) {
+		return R.reachFrom(s, b, s0, s1, p0)
+	}
+	p1, ok1 := s.parent(b, s1)
+	targets := []string{}
+	switch {
+	case s.isVar(s1):
+		if ok1 {
+			targets = append(targets, p1)
+		}
+	default:
+		targets = s.candidates(b, s1)
+	}
+	for _, t := range targets {
+		m := R.equate(s, b, p0, t)
+		if m == nil {
+			continue
+		}
+		m = with(m, s0, s1)
+		if s.admissible(m, s0) {
+			return m
+		}
+	}
+	return nil
+}
+
+// reachFrom binds s0 to s1 when the parent p0 of s0 is a known world: s1 becomes accessible from p0 by binding
+// one of the variables among the ancestors of s1 to p0 or to another known world, the others are tried in turn
+func (R *relation) reachFrom(s *wsolver, b map[string]string, s0, s1, p0 string) map[string]string {
+	// Binding an ancestor can ask to reach s1 from p0 again, the question being answered is not asked twice
+	k := fmt.Sprintf("%s/%s/%v", s0, s1, b)
+	if s.reaching[k] {
+		return nil
+	}
+	if s.reaching == nil {
+		s.reaching = make(map[string]bool)
+	}
+	s.reaching[k] = true
+	defer delete(s.reaching, k)
+	for _, x := range s.ancestors(b, s1) {
+		if !s.isVar(x) {
+			continue
+		}
+		for _, c := range s.candidates(b, p0) {
+			m := R.equate(s, b, x, c)
+			if m == nil || len(m) == len(b) {
+				continue
 			}
-			if o2 != nil {
-				return o2
+			if m = R.findUnification(s, m, s0, s1); m != nil {
+				return m
 			}
 		}
 	}
 	return nil
 }
 
+// equate looks for bindings extending b such that the two worlds are the same
+func (R *relation) equate(s *wsolver, b map[string]string, x, y string) map[string]string {
+	x = s.resolve(b, x)
+	y = s.resolve(b, y)
+	if x == y {
+		return b
+	}
+	xv := s.isVar(x)
+	yv := s.isVar(y)
+	switch {
+	case !xv && !yv:
+		// Skolem functions denote the same world if their arguments do
+		sx, okx := s.symbols[x]
+		sy, oky := s.symbols[y]
+		if !okx || !oky || sx.Value != sy.Value || len(sx.Args) == 0 || len(sx.Args) != len(sy.Args) {
+			return nil
+		}
+		for k := range sx.Args {
+			if b = R.equate(s, b, sx.Args[k].String(), sy.Args[k].String()); b == nil {
+				return nil
+			}
+		}
+		return b
+	case xv && !yv:
+		return R.findUnification(s, b, x, y)
+	case !xv && yv:
+		return R.findUnification(s, b, y, x)
+	}
+	// Both are variables, prefer a known world accessible from both unless the most general instance is wanted
+	for _, c := range s.candidates(b, y, x) {
+		if s.instances {
+			break
+		}
+		m := R.findUnification(s, b, x, c)
+		if m != nil {
+			m = R.findUnification(s, m, y, c)
+		}
+		if m != nil {
+			return m
+		}
+	}
+	return R.findUnification(s, b, x, y)
+}
+
+func with(b map[string]string, k, v string) map[string]string {
+	n := make(map[string]string)
+	for x, y := range b {
+		n[x] = y
+	}
+	n[k] = v
+	return n
+}
+
+// wunify finds a unification that makes the world indexes i and j denote the same world
+// in every frame having the properties of the relation
+func (R *relation) wunify(i, j *worldindex) *unification {
+	return R.wunifyAt(i, j, true)
+}
+
+// wunifyAt works like wunify, when exists is false the unified indexes need not denote a world
+func (R *relation) wunifyAt(i, j *worldindex, exists bool) *unification {
+	if len(i.Symbols) == 0 || len(j.Symbols) == 0 {
+		if len(i.Symbols) == len(j.Symbols) {
+			return &unification{Map: make(map[string]*term)}
+		}
+		return nil
+	}
+	s := newWSolver(R, i, j)
+	s.instances = !exists
+	b := R.equate(s, make(map[string]string), end(i).String(), end(j).String())
+	if b == nil || (exists && !s.exists(b)) {
+		return nil
+	}
+	o := &unification{Map: make(map[string]*term), Paths: make(map[string][]*worldsymbol)}
+	for k := range b {
+		o.Map[k] = s.symbols[s.resolve(b, k)].term()
+	}
+	for k := range b {
+		path := []*worldsymbol{}
+		for _, a := range s.ancestors(b, s.resolve(b, k)) {
+			path = append(path, s.symbols[a])
+		}
+		o.Paths[k] = o.applyToSymbols(path)
+		o.Map[k] = o.applyToTerm(o.Map[k])
+	}
+	return o
+}
+
 func (k *worldskeeper) GetFreeIndividualConstant() *worldsymbol {
 	old := fmt.Sprintf("%d", k.nextConst)
 	k.nextConst = k.nextConst + 1
 	return &worldsymbol{Value: old, Ground: true}
 }
 
-func (k *worldskeeper) GetSkolemFunctionOf(f *formula) *worldsymbol {
+// GetSkolemFunctionOf returns a new skolem function applied to the world variables
+// of the formula index and to its free variables, variables in nonFreeVars are ignored
+func (k *worldskeeper) GetSkolemFunctionOf(f *formula, nonFreeVars *map[string]bool) *term {
 	old := k.nextFunction
 	switch k.nextFunction[0] {
 	case 'f':
@@ -416,25 +1461,16 @@ func (k *worldskeeper) GetSkolemFunctionOf(f *formula) *worldsymbol {
 	for i := 0; i < len(old)-1; i++ {
 		k.nextFunction = k.nextFunction + "'"
 	}
-	// TODO: Implement corret world index value
-	vars := ""
+	args := []*term{}
 	for _, s := range f.Index.Symbols {
 		if !s.Ground {
-			if vars == "" {
-				vars = s.Value
-			} else {
-				vars = fmt.Sprintf("%s,%s", vars, s.Value)
-			}
+			args = append(args, s.term())
 		}
 	}
-	for _, s := range f.Vars {
-		if vars == "" {
-			vars = s
-		} else {
-			vars = fmt.Sprintf("%s,%s", vars, s)
-		}
+	for _, v := range f.GetAllFreeVars(nonFreeVars) {
+		args = append(args, &term{Value: v, IsVar: true})
 	}
-	return &worldsymbol{Value: fmt.Sprintf("%s(%s)", old, vars), Ground: true}
+	return &term{Value: old, Args: args}
 }
 
 func (k *worldskeeper) GetWorldVariable() *worldsymbol {
@@ -457,19 +1493,45 @@ func (k *worldskeeper) GetWorldVariable() *worldsymbol {
 
 // GetAllFreeVars finds free vars in all the subformulas
 func (f *formula) GetAllFreeVars(nonFreeVars *map[string]bool) []string {
-	if nonFreeVars == nil {
-		f := make(map[string]bool)
-		nonFreeVars = &f
+	bound := make(map[string]bool)
+	if nonFreeVars != nil {
+		for k, v := range *nonFreeVars {
+			bound[k] = v
+		}
+	}
+	if f.Terminal == sFORALL || f.Terminal == sEXISTS {
+		for _, v := range f.Vars {
+			bound[v] = true
+		}
+		return f.Operands[len(f.Operands)-1].GetAllFreeVars(&bound)
 	}
 	sub := []string{}
+	seen := make(map[string]bool)
+	add := func(vs []string) {
+		for _, v := range vs {
+			if !seen[v] && !bound[v] {
+				seen[v] = true
+				sub = append(sub, v)
+			}
+		}
+	}
 	for _, o := range f.Operands {
-		sub = append(sub, o.GetAllFreeVars(nonFreeVars)...)
+		add(o.GetAllFreeVars(&bound))
 	}
-	for _, v := range sub {
-		_, ok := (*nonFreeVars)[v]
-		if !ok {
-			sub = append(sub, v)
-		}
+	for _, t := range f.Args {
+		add(t.vars())
 	}
 	return sub
 }
+
+// vars returns the names of the variables occurring in t
+func (t *term) vars() []string {
+	if t.IsVar {
+		return []string{t.Value}
+	}
+	out := []string{}
+	for _, a := range t.Args {
+		out = append(out, a.vars()...)
+	}
+	return out
+}
@@ -1,10 +1,12 @@
 package moltp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
-	"sync"
+	"unicode/utf8"
 )
 
 const (
@@ -17,12 +19,22 @@ const (
 	sAND     = "And"
 	sOR      = "Or"
 	sNOT     = "Not"
+	sNEXT    = "Next"
+	sEQUAL   = "="
 )
 
-var (
-	sEInit    = &sync.Once{}
-	sEncoding = make(map[string]string)
-)
+// agentNext is the agent of the temporal operator \bigcirc, its relation is serial and by default
+// it is contained in the relation of \Box and \Diamond of the temporal system LTL
+const agentNext = "next"
+
+// ErrNoSolution is returned when every sequent was reduced and resolved without finding the empty sequent
+var ErrNoSolution = errors.New("No solution found")
+
+// ruleAxiom is the justification of the sequents holding an axiom
+const ruleAxiom = "AX"
+
+// defaultMaxResolutions is the number of resolution steps tried when Prover.MaxResolutions is not set
+const defaultMaxResolutions = 1000
 
 // Utility functions
 func copyTopFormulaLevel(src *formula) *formula {
@@ -36,6 +48,8 @@ func copyTopFormulaLevel(src *formula) *formula {
 	dst.Terminal = src.Terminal
 	dst.Index = src.Index
 	dst.Vars = append([]string{}, src.Vars...)
+	dst.Args = append([]*term{}, src.Args...)
+	dst.Agent = src.Agent
 
 	return dst
 }
@@ -46,7 +60,7 @@ func formulaArrayToString(a []*formula) string {
 		if out == "" {
 			out = fmt.Sprintf("%s", f)
 		} else {
-			out = fmt.Sprintf("%s, %s", a, f)
+			out = fmt.Sprintf("%s, %s", out, f)
 		}
 	}
 	return out
@@ -56,7 +70,7 @@ func operatorPreceeds(a, b *token) bool {
 	if a.MuOp {
 		return false
 	}
-	if a.UnOp && !b.UnOp {
+	if a.UnOp && !b.UnOp && !b.MuOp {
 		return true
 	}
 	if a.BiOp && b.BiOp {
@@ -102,66 +116,69 @@ func operatorPreceeds(a, b *token) bool {
 }
 
 func matchIndex(s string) (*token, error) {
+	if len(s) < 2 {
+		return nil, syntaxError(endOfInput, endOfInput, "index", "missing index after _")
+	}
 	if s[1] == '{' {
+		// indexes can hold braces, like w^{a}:0
+		depth := 0
 		for j := 2; j < len(s); j++ {
-			if s[j] == '}' {
-				return &token{IsIn: true, Value: fmt.Sprintf("%s", s[1:j]), Skip: j + 2}, nil
+			switch s[j] {
+			case '{':
+				depth = depth + 1
+			case '}':
+				if depth == 0 {
+					if j == 2 {
+						return nil, syntaxError(0, j+1, "index", "empty index")
+					}
+					return &token{IsIn: true, Value: fmt.Sprintf("%s", s[2:j]), Skip: j + 1}, nil
+				}
+				depth = depth - 1
 			}
 		}
-		return nil, fmt.Errorf("missing closing } in index")
+		return nil, syntaxError(len(s), len(s), "}", "missing closing } in index")
+	}
+	if !isIdentifierChar(s[1]) {
+		return nil, syntaxError(1, 1, "index", "missing index after _")
 	}
 	return &token{IsIn: true, Value: fmt.Sprintf("%c", s[1]), Skip: 2}, nil
 }
 
-// TODO: Find a better way to init this object
-func initsEncoding() {
-	sEncoding[sBOX] = "\\Box"
-	sEncoding[sDIAMOND] = "\\Diamond"
-	sEncoding[sEXISTS] = "\\exists"
-	sEncoding[sFORALL] = "\\forall"
-	sEncoding[sIFF] = "\\iff"
-	sEncoding[sIMPLY] = "\\to"
-	sEncoding[sAND] = "\\land"
-	sEncoding[sOR] = "\\lor"
-	sEncoding[sNOT] = "\\lnot"
-}
-
-func toTextRepr(s string) string {
-	sEInit.Do(initsEncoding)
-	for k, v := range sEncoding {
-		s = strings.Replace(s, k, v, -1)
-	}
-	return s
-}
-
-// the len(string) was left here instead of the immediate value to better understand from where the value came
-func matchOperator(o, t byte) *token {
-	switch o {
-	case 'B':
-		return &token{IsOp: true, UnOp: true, Value: sBOX, Skip: len("\\Box")}
-	case 'D':
-		return &token{IsOp: true, UnOp: true, Value: sDIAMOND, Skip: len("\\Diamond")}
-	case 'e':
-		return &token{IsOp: true, MuOp: true, Value: sEXISTS, Skip: len("\\exists")}
-	case 'f':
-		return &token{IsOp: true, MuOp: true, Value: sFORALL, Skip: len("\\forall")}
-	case 'i':
-		return &token{IsOp: true, BiOp: true, Value: sIFF, Skip: len("\\iff")}
-	case 't':
-		return &token{IsOp: true, BiOp: true, Value: sIMPLY, Skip: len("\\to")}
-	}
-	switch t {
-	case 'a':
-		return &token{IsOp: true, BiOp: true, Value: sAND, Skip: len("\\land")}
-	case 'o':
-		return &token{IsOp: true, BiOp: true, Value: sOR, Skip: len("\\lor")}
-	case 'n':
-		return &token{IsOp: true, UnOp: true, Value: sNOT, Skip: len("\\lnot")}
+// texOperators holds the token of each TeX command read as an operator
+var texOperators = map[string]token{
+	"Box":     {IsOp: true, UnOp: true, Value: sBOX},
+	"Diamond": {IsOp: true, UnOp: true, Value: sDIAMOND},
+	"bigcirc": {IsOp: true, UnOp: true, Value: sBOX, Agent: agentNext},
+	"exists":  {IsOp: true, MuOp: true, Value: sEXISTS},
+	"forall":  {IsOp: true, MuOp: true, Value: sFORALL},
+	"iff":     {IsOp: true, BiOp: true, Value: sIFF},
+	"to":      {IsOp: true, BiOp: true, Value: sIMPLY},
+	"land":    {IsOp: true, BiOp: true, Value: sAND},
+	"lor":     {IsOp: true, BiOp: true, Value: sOR},
+	"lnot":    {IsOp: true, UnOp: true, Value: sNOT},
+}
+
+// matchOperator reads the TeX command at the beginning of s, which starts with a backslash,
+// it returns nil if the command is not an operator
+func matchOperator(s string) *token {
+	n := 1
+	for n < len(s) && isLetter(s[n]) {
+		n = n + 1
 	}
-	return nil
+	t, ok := texOperators[s[1:n]]
+	if !ok {
+		return nil
+	}
+	t.Skip = n
+	return &t
 }
 
-func nextToken(s string) (*token, error) {
+func nextToken(s, syntax string) (*token, error) {
+	if syntax != SyntaxTeX {
+		if t := matchPlainOperator(s, syntax); t != nil {
+			return withAgent(t, s)
+		}
+	}
 	switch s[0] {
 	case '(':
 		return &token{IsLB: true, Value: "Round", Skip: 1}, nil
@@ -176,40 +193,205 @@ func nextToken(s string) (*token, error) {
 	case '}':
 		return &token{IsRB: true, Value: "Curly", Skip: 1}, nil
 	case '\\':
-		return matchOperator(s[1], s[2]), nil
+		if len(s) > 1 && (s[1] == ',' || s[1] == ';' || s[1] == ' ') {
+			// LaTeX spaces
+			return &token{Skip: 2}, nil
+		}
+		t := matchOperator(s)
+		if t == nil {
+			return nil, nil
+		}
+		return withAgent(t, s)
 	case '_':
 		return matchIndex(s)
+	case ' ', '\t', '\n', '\r':
+		return &token{Skip: 1}, nil
 	default:
-		// TODO: supoort multi letters varaibles
-		if len(s) > 4 && s[1] == '(' {
-			skip := 2
-			vlist := []string{}
-			vars := ""
-			closed := false
-			for i := 2; i < len(s); i++ {
-				skip = skip + 1
-				if s[i] == ')' {
-					closed = true
-					break
-				}
-				if s[i] == ',' {
-					continue
-				}
-				vlist = append(vlist, fmt.Sprintf("%c", s[i]))
-				if vars == "" {
-					vars = fmt.Sprintf("%c", s[i])
-				} else {
-					vars = fmt.Sprintf("%s,%c", vars, s[i])
+		if s[0] >= utf8.RuneSelf {
+			// a character which is not an operator of the syntax, read whole so that it is reported as written
+			r, n := utf8.DecodeRuneInString(s)
+			return nil, syntaxError(0, n, "formula", fmt.Sprintf("unexpected character %c", r))
+		}
+		if !isIdentifierChar(s[0]) {
+			return &token{IsTe: true, Value: fmt.Sprintf("%c", s[0]), Skip: 1}, nil
+		}
+		v := matchIdentifier(s)
+		skip := len(v)
+		if v == "K" {
+			if t, ok := matchKnowledge(s[skip:], syntax); ok {
+				return t, nil
+			}
+		}
+		t := &token{IsTe: true, Value: v, Skip: skip}
+		if skip < len(s) && s[skip] == '(' {
+			args, n, err := matchArguments(s[skip:])
+			if err != nil {
+				return nil, shifted(err, skip)
+			}
+			t.Args = args
+			t.Skip = skip + n
+		}
+		if n := matchEquals(s[t.Skip:]); n > 0 {
+			// s = t is the predicate = applied to the two terms
+			r, m, err := readTerm(s[t.Skip+n:])
+			if err != nil {
+				return nil, shifted(err, t.Skip+n)
+			}
+			l := &term{Value: t.Value, Args: t.Args}
+			return &token{IsTe: true, Value: sEQUAL, Args: []*term{l, r}, Skip: t.Skip + n + m}, nil
+		}
+		return t, nil
+	}
+}
+
+// withAgent reads the agent of an indexed modality like \Box_{a}, s starts with the modality t
+func withAgent(t *token, s string) (*token, error) {
+	if (t.Value == sBOX || t.Value == sDIAMOND) && t.Agent == "" && t.Skip+1 < len(s) && s[t.Skip] == '_' {
+		i, err := matchIndex(s[t.Skip:])
+		if err != nil {
+			return nil, shifted(err, t.Skip)
+		}
+		t.Agent = i.Value
+		t.Skip = t.Skip + i.Skip
+	}
+	return t, nil
+}
+
+// matchEquals returns how many char are read up to an equals sign, 0 if s does not start with one
+func matchEquals(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '=':
+			return i + 1
+		}
+		return 0
+	}
+	return 0
+}
+
+// readTerm reads a term, like f(x), preceded by optional spaces
+// it returns the term and how many char were read
+func readTerm(s string) (*term, int, error) {
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i = i + 1
+	}
+	if i >= len(s) || !isIdentifierChar(s[i]) {
+		return nil, 0, syntaxError(i, i, "term", "missing term after =")
+	}
+	t := &term{Value: matchIdentifier(s[i:])}
+	i = i + len(t.Value)
+	if i < len(s) && s[i] == '(' {
+		args, n, err := matchArguments(s[i:])
+		if err != nil {
+			return nil, 0, shifted(err, i)
+		}
+		t.Args = args
+		i = i + n
+	}
+	return t, i, nil
+}
+
+// matchKnowledge reads the agent of a knowledge operator K_{a}, s starts right after the K
+// K_{a} is the terminal K with an index when it is not followed by a formula
+func matchKnowledge(s, syntax string) (*token, bool) {
+	if len(s) < 2 || s[0] != '_' || s[1] != '{' {
+		return nil, false
+	}
+	i, err := matchIndex(s)
+	if err != nil {
+		return nil, false
+	}
+	rest := strings.TrimLeft(s[i.Skip:], " \t\n\r")
+	if rest == "" {
+		return nil, false
+	}
+	switch rest[0] {
+	case ')', ']', '}', ',', '_':
+		return nil, false
+	case '\\':
+		if len(rest) < 3 {
+			return nil, false
+		}
+	}
+	if o, err := nextToken(rest, syntax); err != nil || o == nil || o.BiOp {
+		return nil, false
+	}
+	return &token{IsOp: true, UnOp: true, Value: sBOX, Agent: i.Value, Skip: len("K") + i.Skip}, true
+}
+
+func isIdentifierChar(c byte) bool {
+	return isLetter(c) || c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// matchIdentifier returns the longest identifier at the beginning of s
+// identifiers are made of letters, digits, primes and underscores followed by a letter or a digit
+// an underscore followed by anything else starts an index, like in p_{1}
+func matchIdentifier(s string) string {
+	i := 0
+	for i < len(s) {
+		if isIdentifierChar(s[i]) || i > 0 && s[i] == '\'' {
+			i = i + 1
+			continue
+		}
+		if i > 0 && s[i] == '_' && i+1 < len(s) && isIdentifierChar(s[i+1]) {
+			i = i + 2
+			continue
+		}
+		break
+	}
+	return s[:i]
+}
+
+// matchArguments reads a comma separated list of terms enclosed in round brakets
+// a term is an identifier optionally followed by its own list of arguments, like f(g(x))
+// it returns the terms and how many char were read
+func matchArguments(s string) ([]*term, int, error) {
+	args := []*term{}
+	expectArg := true
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r':
+			continue
+		case s[i] == ')':
+			if expectArg {
+				return nil, 0, syntaxError(i, i+1, "argument", "missing argument")
+			}
+			return args, i + 1, nil
+		case s[i] == ',':
+			if expectArg {
+				return nil, 0, syntaxError(i, i+1, "argument", "missing argument")
+			}
+			expectArg = true
+		case isIdentifierChar(s[i]) && expectArg:
+			v := matchIdentifier(s[i:])
+			t := &term{Value: v}
+			i = i + len(v)
+			if i < len(s) && s[i] == '(' {
+				sub, n, err := matchArguments(s[i:])
+				if err != nil {
+					return nil, 0, shifted(err, i)
 				}
+				t.Args = sub
+				i = i + n
 			}
-			if !closed {
-				return nil, fmt.Errorf("Missing closing parenthesis for %s", s)
+			i = i - 1
+			args = append(args, t)
+			expectArg = false
+		default:
+			if expectArg {
+				return nil, 0, syntaxError(i, i, "argument", "unexpected symbol in arguments")
 			}
-			v := fmt.Sprintf("%c", s[0])
-			return &token{IsTe: true, Value: v, Skip: skip, Vars: vlist}, nil
+			return nil, 0, syntaxError(i, i, ", or )", "unexpected symbol in arguments")
 		}
-		return &token{IsTe: true, Value: fmt.Sprintf("%c", s[0]), Skip: 1}, nil
 	}
+	return nil, 0, syntaxError(len(s), len(s), ")", "missing closing parenthesis of the arguments")
 }
 
 // Based on Shunting Yard Algorithm
@@ -226,16 +408,23 @@ func nextToken(s string) (*token, error) {
 // 11.                     Pop operators from the stack onto the output queue.
 // 12.             Pop the left bracket from the stack and discard it
 // 13. While there are operators on the stack, pop them to the queue
-func tokenize(s string, term byte) ([]*token, error) {
+// The syntax of s is guessed when it is SyntaxAuto, errors are *ParseError holding the byte offset of the problem
+func tokenize(s, syntax string) ([]*token, error) {
+	if syntax == SyntaxAuto {
+		syntax = detectSyntax(s)
+	}
 	var tokens []*token
 	var ops []*token
-	var offset int
-	segment := s
-	t, err := nextToken(s)
-	if err != nil {
-		return tokens, err
-	}
-	for t != nil {
+	for offset := 0; offset < len(s); {
+		t, err := nextToken(s[offset:], syntax)
+		if err != nil {
+			return tokens, shifted(err, offset)
+		}
+		if t == nil {
+			name := "\\" + matchIdentifier(s[offset+1:])
+			return tokens, syntaxError(offset, offset+len(name), "operator", fmt.Sprintf("unknown operator %s", name))
+		}
+		t.Offset = offset
 		if t.IsTe || t.IsIn {
 			tokens = append(tokens, t)
 		}
@@ -255,49 +444,64 @@ func tokenize(s string, term byte) ([]*token, error) {
 			ops = append(ops, t)
 		}
 		if t.IsRB {
+			matched := false
 			for len(ops) > 0 {
 				k := ops[len(ops)-1]
-				if k.IsLB && k.Value == t.Value {
-					ops = ops[:len(ops)-1]
+				ops = ops[:len(ops)-1]
+				if k.IsLB {
+					if k.Value != t.Value {
+						return tokens, syntaxError(offset, offset+1, closingBrackets[k.Value], fmt.Sprintf("%s bracket closed by a %s one", k.Value, t.Value))
+					}
+					matched = true
 					break
-				} else {
-					ops = ops[:len(ops)-1]
-					tokens = append(tokens, k)
-				}
-				if len(ops) == 0 {
-					return tokens, fmt.Errorf("missing opening brakets")
 				}
+				tokens = append(tokens, k)
 			}
-		}
-		offset = offset + t.Skip
-		segment := segment[offset:]
-		if len(segment) < 1 {
-			break
-		} else {
-			t, err = nextToken(segment)
-			if err != nil {
-				return tokens, err
+			if !matched {
+				return tokens, syntaxError(offset, offset+1, "", "missing opening brakets")
 			}
 		}
+		offset = offset + t.Skip
 	}
 	for i := len(ops) - 1; i >= 0; i-- {
+		if ops[i].IsLB {
+			return tokens, syntaxError(ops[i].Offset, ops[i].Offset+1, closingBrackets[ops[i].Value], "missing closing brakets")
+		}
 		tokens = append(tokens, ops[i])
-		// ops = ops[:len(ops)-1]
 	}
 	return tokens, nil
 }
 
+// negate returns \lnot A, the negation is moved inside \bigcirc since the next world is unique:
+// \lnot \bigcirc A = \bigcirc \lnot A
+func negate(A *formula) *formula {
+	if A.is(sBOX) && A.Agent == agentNext {
+		return &formula{Terminal: sBOX, Operands: []*formula{negate(A.Operands[0])}, Index: A.Index, Agent: agentNext}
+	}
+	return &formula{Terminal: sNOT, Operands: []*formula{A}}
+}
+
 func reduceFormulas(f *formula) *formula {
+	if len(f.Operands) == 0 {
+		return f
+	}
 	for i, g := range f.Operands {
 		f.Operands[i] = reduceFormulas(g)
 	}
 	switch f.Terminal {
+	case sNOT:
+		if g := f.Operands[0]; g.is(sBOX) && g.Agent == agentNext {
+			n := negate(g)
+			n.Index = f.Index
+			return n
+		}
+		return f
 	case sDIAMOND:
 		// \Diamond A = \lnot \Box \lnot A
 		A := f.Operands[0]
-		g0 := &formula{Terminal: sNOT, Operands: []*formula{A}}
-		g1 := &formula{Terminal: sBOX, Operands: []*formula{g0}}
-		return &formula{Terminal: sNOT, Operands: []*formula{g1}}
+		g0 := negate(A)
+		g1 := &formula{Terminal: sBOX, Operands: []*formula{g0}, Agent: f.Agent}
+		return negate(g1)
 	case sIFF:
 		// A <-> B = ( A \to B ) \and ( B \to A ) = \lnot ( (A \to B) \to \lnot ( B \to A) )
 		A := f.Operands[0]
@@ -311,18 +515,18 @@ func reduceFormulas(f *formula) *formula {
 		// A \land B = \lnot ( A \to \lnot B )
 		A := f.Operands[0]
 		B := f.Operands[1]
-		g0 := &formula{Terminal: sNOT, Operands: []*formula{B}}
+		g0 := negate(B)
 		g1 := &formula{Terminal: sIMPLY, Operands: []*formula{A, g0}}
 		return &formula{Terminal: sNOT, Operands: []*formula{g1}}
 	case sOR:
 		// A \lor B = \lnot A \to B
 		A := f.Operands[0]
 		B := f.Operands[1]
-		g0 := &formula{Terminal: sNOT, Operands: []*formula{A}}
+		g0 := negate(A)
 		return &formula{Terminal: sIMPLY, Operands: []*formula{g0, B}}
 	case sEXISTS:
 		// \exists x p = \lnot \forall x \lnot p
-		g0 := &formula{Terminal: sNOT, Operands: []*formula{f.Operands[len(f.Operands)-1]}}
+		g0 := negate(f.Operands[len(f.Operands)-1])
 		g1 := &formula{Terminal: sFORALL, Operands: append(f.Operands[:len(f.Operands)-1], g0), Vars: f.Vars}
 		return &formula{Terminal: sNOT, Operands: []*formula{g1}}
 	default:
@@ -330,81 +534,152 @@ func reduceFormulas(f *formula) *formula {
 	}
 }
 
+// pushNext moves the negations inside the next operators leaving the other connectives as they are
+func pushNext(f *formula) *formula {
+	for i, g := range f.Operands {
+		f.Operands[i] = pushNext(g)
+	}
+	if f.is(sNOT) {
+		if g := f.Operands[0]; g.is(sBOX) && g.Agent == agentNext {
+			n := negate(g)
+			n.Index = f.Index
+			return n
+		}
+	}
+	return f
+}
+
+// isVariable checks that f can be the variable of a quantifier
+func isVariable(f *formula) bool {
+	return len(f.Operands) == 0 && len(f.Args) == 0 && len(f.Index.Symbols) == 0 && f.Terminal != "" && isIdentifierChar(f.Terminal[0])
+}
+
+// genFormulasTree builds the formula tree of the tokens returned by tokenize
+// errors are *ParseError holding the byte offset of the problem
 func genFormulasTree(tokens []*token) (*formula, error) {
 	var formulas []*formula
+	starts := make(map[*formula]int) // offset of the first token of each formula
 	for _, t := range tokens {
 		if t.IsOp {
 			if t.MuOp {
 				// We must have (1) a formula and a (2) list of variables name
 				// Something like forall x \Box x -> x
 				if len(formulas) < 2 {
-					return formulas[0], fmt.Errorf("missing arguments for multi operator %s", t.Value)
+					return nil, syntaxError(t.Offset, t.Offset+t.Skip, "variables and formula", fmt.Sprintf("missing arguments for multi operator %s", t.Value))
 				}
 				f := &formula{}
 				f.Terminal = t.Value
 				// (1) This should find the formula
 				m := formulas[len(formulas)-1]
 				formulas = formulas[:len(formulas)-1]
+				if v := formulas[len(formulas)-1]; !isVariable(v) {
+					return nil, syntaxError(starts[v], starts[v], "variable", fmt.Sprintf("bad variable of multi operator %s", t.Value))
+				}
 				f.Operands = append(f.Operands, formulas[len(formulas)-1])
 				f.Vars = append(f.Vars, formulas[len(formulas)-1].Terminal)
 				formulas = formulas[:len(formulas)-1]
 				// (2) this should find all the variables, mind that they are in the reversed order
-				for k := len(formulas) - 1; k >= 0; k-- {
-					if formulas[k].Terminal == "," {
-						if k-1 < 0 {
-							return formulas[0], fmt.Errorf("missing argument for multi operator %s", t.Value)
-						}
-						f.Operands = append([]*formula{formulas[k-1]}, f.Operands...)
-						f.Vars = append(f.Vars, formulas[k-1].Terminal)
-					} else {
-						formulas = formulas[:k+1]
-						break
+				k := len(formulas) - 1
+				for k >= 0 && formulas[k].Terminal == "," {
+					if k-1 < 0 {
+						return nil, syntaxError(t.Offset, t.Offset+t.Skip, "variable", fmt.Sprintf("missing argument for multi operator %s", t.Value))
 					}
+					if v := formulas[k-1]; !isVariable(v) {
+						return nil, syntaxError(starts[v], starts[v], "variable", fmt.Sprintf("bad variable of multi operator %s", t.Value))
+					}
+					f.Operands = append([]*formula{formulas[k-1]}, f.Operands...)
+					f.Vars = append([]string{formulas[k-1].Terminal}, f.Vars...)
+					k = k - 2
 				}
+				formulas = formulas[:k+1]
 
 				f.Operands = append(f.Operands, m)
 				formulas = append(formulas, f)
+				starts[f] = t.Offset
 			}
 			if t.BiOp {
 				if len(formulas) < 2 {
-					return formulas[0], fmt.Errorf("missing argument for binary operator %s", t.Value)
+					return nil, syntaxError(t.Offset, t.Offset+t.Skip, "formula", fmt.Sprintf("missing argument for binary operator %s", t.Value))
 				}
 				f := &formula{}
 				f.Terminal = t.Value
 				f.Operands = append(f.Operands, formulas[len(formulas)-2:]...)
 				formulas = formulas[:len(formulas)-2]
 				formulas = append(formulas, f)
+				starts[f] = starts[f.Operands[0]]
 			}
 			if t.UnOp {
 				if len(formulas) < 1 {
-					return formulas[0], fmt.Errorf("missing argument for unary operator %s", t.Value)
+					return nil, syntaxError(t.Offset, t.Offset+t.Skip, "formula", fmt.Sprintf("missing argument for unary operator %s", t.Value))
 				}
 				f := &formula{}
 				f.Terminal = t.Value
+				f.Agent = t.Agent
 				f.Operands = append(f.Operands, formulas[len(formulas)-1])
 				formulas = formulas[:len(formulas)-1]
 				formulas = append(formulas, f)
+				starts[f] = t.Offset
 			}
 		}
 		if t.IsTe {
-			formulas = append(formulas, &formula{Terminal: t.Value, Vars: t.Vars})
+			f := &formula{Terminal: t.Value, Args: t.Args}
+			formulas = append(formulas, f)
+			starts[f] = t.Offset
 		}
 		if t.IsIn {
 			if len(formulas) < 1 {
-				return formulas[0], fmt.Errorf("trying to assign index %s to nothing", t.Value)
+				return nil, syntaxError(t.Offset, t.Offset+t.Skip, "formula", fmt.Sprintf("trying to assign index %s to nothing", t.Value))
 			}
 			formulas[len(formulas)-1].Index = worldindex{[]*worldsymbol{&worldsymbol{Ground: true, Value: t.Value}}}
 		}
 	}
-	return reduceFormulas(formulas[0]), nil
+	if len(formulas) == 0 {
+		return nil, syntaxError(endOfInput, endOfInput, "formula", "missing formula")
+	}
+	if len(formulas) > 1 {
+		return nil, syntaxError(starts[formulas[1]], starts[formulas[1]], "operator", "missing operator")
+	}
+	bindVariables(formulas[0], make(map[string]bool))
+	return formulas[0], nil
+}
+
+// bindVariables marks as variables the arguments named after the variables of a quantifier in its scope
+// all the other arguments are constants
+func bindVariables(f *formula, bound map[string]bool) {
+	if f.is(sFORALL) || f.is(sEXISTS) {
+		scope := make(map[string]bool)
+		for k, v := range bound {
+			scope[k] = v
+		}
+		for _, v := range f.Vars {
+			scope[v] = true
+		}
+		bindVariables(f.Operands[len(f.Operands)-1], scope)
+		return
+	}
+	for _, o := range f.Operands {
+		bindVariables(o, bound)
+	}
+	for _, t := range f.Args {
+		bindTermVariables(t, bound)
+	}
+}
+
+func bindTermVariables(t *term, bound map[string]bool) {
+	if len(t.Args) == 0 && bound[t.Value] {
+		t.IsVar = true
+	}
+	for _, a := range t.Args {
+		bindTermVariables(a, bound)
+	}
 }
 
 func encodeSequent(s *Sequent) (RawSequent, error) {
 	rs := RawSequent{}
 
 	rs.Name = s.Name
-	rs.Left = toTextRepr(formulaArrayToString(s.Left))
-	rs.Right = toTextRepr(formulaArrayToString(s.Right))
+	rs.Left = formulasLatex(s.Left)
+	rs.Right = formulasLatex(s.Right)
 	rs.Justification = ""
 	for _, j := range s.Justification {
 		if rs.Justification == "" {
@@ -417,185 +692,320 @@ func encodeSequent(s *Sequent) (RawSequent, error) {
 	return rs, nil
 }
 
-func (p *Prover) proveFormula(f *formula) ([]*Sequent, error) {
+func (p *Prover) logState(title string, unreduced, solution, reduced []*Sequent) {
+	if !p.Debug {
+		return
+	}
+	log.Println("******************************")
+	log.Printf("**** %s ****\n", title)
+	log.Println("******************************")
+	log.Println("Unreduced:")
+	for _, u := range unreduced {
+		log.Printf("\t%s\n", u)
+	}
+	if len(solution) < 1 {
+		log.Println("Solution is empty")
+	} else {
+		log.Println("Partial Solution:")
+		for _, s := range solution {
+			log.Printf("\t%s\n", s)
+		}
+	}
+	if len(reduced) < 1 {
+		log.Println("Reduced list is empty")
+	} else {
+		log.Println("Reduced:")
+		for _, s := range reduced {
+			log.Printf("\t%s\n", s)
+		}
+	}
+}
+
+// appendDerivation appends to the solution s and all the sequents it was derived from
+// which are not already there, premises come before their conclusions
+func appendDerivation(solution []*Sequent, s *Sequent, sequents map[string]*Sequent) []*Sequent {
+	for _, k := range solution {
+		if k == s {
+			return solution
+		}
+	}
+	if len(s.Justification) > 0 {
+		for _, name := range s.Justification[1:] {
+			if parent, ok := sequents[name]; ok {
+				solution = appendDerivation(solution, parent, sequents)
+			}
+		}
+	}
+	return append(solution, s)
+}
+
+// checkLimits returns a LimitError if the search has to be stopped
+func (p *Prover) checkLimits(ctx context.Context, steps, count, size int) error {
+	select {
+	case <-ctx.Done():
+		return &LimitError{Limit: LimitContext, Err: ctx.Err()}
+	default:
+	}
+	if p.MaxSteps > 0 && steps >= p.MaxSteps {
+		return &LimitError{Limit: LimitSteps, Value: p.MaxSteps}
+	}
+	if p.MaxSequents > 0 && count >= p.MaxSequents {
+		return &LimitError{Limit: LimitSequents, Value: p.MaxSequents}
+	}
+	if p.MaxSize > 0 && size >= p.MaxSize {
+		return &LimitError{Limit: LimitSize, Value: p.MaxSize}
+	}
+	return nil
+}
+
+// worldsOf returns the world indexes named by the formulas of s, each index comes before its extensions
+func worldsOf(s *Sequent) []worldindex {
+	out := []worldindex{}
+	for _, f := range append(append([]*formula{}, s.Left...), s.Right...) {
+		for k := len(f.Index.Symbols) - 1; k >= 0; k-- {
+			out = append(out, worldindex{f.Index.Symbols[k:]})
+		}
+	}
+	return out
+}
+
+// instantiateAxioms returns a sequent |a|_{i} <- for every axiom a and every world i named by s
+// which was not already seen and is at most depth steps away from the root
+func instantiateAxioms(s *Sequent, axioms []*formula, depth int, worlds map[string]bool) []*Sequent {
+	out := []*Sequent{}
+	if len(axioms) == 0 {
+		return out
+	}
+	for _, i := range worldsOf(s) {
+		if worlds[i.String()] || len(i.Symbols)-1 > depth {
+			continue
+		}
+		worlds[i.String()] = true
+		for _, a := range axioms {
+			t := copyTopFormulaLevel(a)
+			t.Index = i
+			out = append(out, &Sequent{Left: []*formula{t}, Justification: []string{ruleAxiom, s.Name}})
+		}
+	}
+	return out
+}
+
+func (p *Prover) proveFormula(ctx context.Context, pr *problem) ([]*Sequent, error) {
+	f := pr.Goal
 	i := 1
+	steps := 0
 	solution := []*Sequent{}
 	unreduced := []*Sequent{}
-	reduced := []*Sequent{}
+	// Reduced sequents are split between the ones already used by the resolution rule
+	// and the ones still waiting to be used, like in the given clause algorithm
+	processed := []*Sequent{}
+	unprocessed := []*Sequent{}
+	sequents := make(map[string]*Sequent)
+	p.sequents = sequents
+	seen := make(map[string]bool)
+	resolutions := 0
+	maxResolutions := p.MaxResolutions
+	if maxResolutions <= 0 {
+		maxResolutions = defaultMaxResolutions
+	}
+
+	// Witnesses are only reused inside a search, a previous call on the same Prover gives none
+	p.worldsKeeper.forget()
+	root := worldindex{[]*worldsymbol{p.worldsKeeper.GetFreeIndividualConstant()}}
+	f.Index = root
+	for _, h := range pr.Premises {
+		h.Index = root
+	}
 
-	f.Index = worldindex{[]*worldsymbol{p.worldsKeeper.GetFreeIndividualConstant()}}
+	// Formulas are interned so that duplicate sequents are found by the ids of their formulas
+	formulas := newFormulaTable()
 
 	unreduced = append(unreduced, &Sequent{Right: []*formula{f}, Name: "S1"})
+	formulas.internSequent(unreduced[0])
+	sequents["S1"] = unreduced[0]
+	size := unreduced[0].size()
 
-	for len(unreduced) > 0 {
-		if p.Debug {
-			log.Println("******************************")
-			log.Println("**** Applying rules loop *****")
-			log.Println("******************************")
-			log.Println("Unreduced:")
-			for _, u := range unreduced {
-				log.Printf("\t%s\n", u)
-			}
-			if len(solution) < 1 {
-				log.Println("Solution is empty")
-			} else {
-				log.Println("Partial Solution:")
-				for _, s := range solution {
-					log.Printf("\t%s\n", s)
-				}
-			}
-			if len(reduced) < 1 {
-				log.Println("Reduced list is empty")
-			} else {
-				log.Println("Reduced:")
-				for _, s := range reduced {
-					log.Printf("\t%s\n", s)
-				}
+	// Every premise holds in the root world
+	for _, h := range pr.Premises {
+		i = i + 1
+		s := &Sequent{Left: []*formula{h}, Name: fmt.Sprintf("S%d", i)}
+		formulas.internSequent(s)
+		unreduced = append(unreduced, s)
+		sequents[s.Name] = s
+		size = size + s.size()
+	}
+
+	// Axioms hold in every world, they are added each time a sequent names a new world
+	worlds := make(map[string]bool)
+	depth := p.AxiomDepth
+	if depth <= 0 {
+		depth = pr.Depth
+	}
+	if depth <= 0 {
+		depth = f.modalDepth()
+		for _, h := range pr.Premises {
+			if d := h.modalDepth(); d > depth {
+				depth = d
 			}
 		}
+	}
+	addAxioms := func(s *Sequent) []*Sequent {
+		out := instantiateAxioms(s, pr.Axioms, depth, worlds)
+		for _, n := range out {
+			i = i + 1
+			n.Name = fmt.Sprintf("S%d", i)
+			formulas.internSequent(n)
+			sequents[n.Name] = n
+			size = size + n.size()
+		}
+		return out
+	}
+	unreduced = append(unreduced, addAxioms(unreduced[0])...)
 
-		pushLastInSolution := false
-		last := unreduced[len(unreduced)-1]
-		new := []*Sequent{}
-
-		// Try to apply each rule
-		for _, rule := range p.Rules {
-			s, err := rule.applyRuleTo(last)
-			if err != nil {
+	for {
+		for len(unreduced) > 0 {
+			p.logState("Applying rules loop", unreduced, solution, unprocessed)
+			if err := p.checkLimits(ctx, steps, i, size); err != nil {
 				return solution, err
 			}
-			if s != nil {
-				if p.Debug {
-					log.Printf("Rule %s was applied on %s\n", rule.getName(), last)
-				}
-				pushLastInSolution = true
-				// The rule was applied successfully
-				i = i + 1
-				s.Name = fmt.Sprintf("S%d", i)
-				s.Justification = []string{rule.getName(), last.Name}
-
-				if len(s.Left) == 0 && len(s.Right) == 0 {
-					// A solution was found
-					solution = append(solution, s)
-					return solution, nil
+
+			pushLastInSolution := false
+			last := unreduced[len(unreduced)-1]
+			new := []*Sequent{}
+
+			// Try to apply each rule
+			for _, rule := range p.Rules {
+				s, err := rule.applyRuleTo(last)
+				if err != nil {
+					return solution, err
 				}
-				new = append(new, s)
-				if p.Debug {
-					log.Printf("New sequent is %s\n", s)
+				if s != nil {
+					if p.Debug {
+						log.Printf("Rule %s was applied on %s\n", rule.getName(), last)
+					}
+					pushLastInSolution = true
+					// The rule was applied successfully
+					i = i + 1
+					steps = steps + 1
+					s.Name = fmt.Sprintf("S%d", i)
+					s.Justification = []string{rule.getName(), last.Name}
+					s.sortFormulas()
+					formulas.internSequent(s)
+					sequents[s.Name] = s
+					size = size + s.size()
+
+					if len(s.Left) == 0 && len(s.Right) == 0 {
+						// A solution was found
+						solution = append(solution, s)
+						return solution, nil
+					}
+					new = append(new, s)
+					if p.Debug {
+						log.Printf("New sequent is %s\n", s)
+					}
+					new = append(new, addAxioms(s)...)
 				}
+				// else the rule was not appliable
 			}
-			// else the rule was not appliable
-		}
 
-		if pushLastInSolution {
-			solution = append(solution, last)
-		} else {
-			// If no rule was appliable to the last element
-			// we move it at the beginning of the reduced rules
-			reduced = append(reduced, last)
+			if pushLastInSolution {
+				solution = append(solution, last)
+			} else {
+				// If no rule was appliable to the last element
+				// we move it at the end of the reduced sequents
+				unprocessed = append(unprocessed, last)
+			}
+			unreduced = append(unreduced[:len(unreduced)-1], new...)
 		}
-		unreduced = append(unreduced[:len(unreduced)-1], new...)
 
-	}
+		p.logState("Unreduced are over", unreduced, solution, unprocessed)
 
-	if p.Debug {
-		log.Println("******************************")
-		log.Println("***** Unreduced are over *****")
-		log.Println("******************************")
-		log.Println("Unreduced:")
-		for _, u := range unreduced {
-			log.Printf("\t%s\n", u)
-		}
-		if len(solution) < 1 {
-			log.Println("Solution is empty")
-		} else {
-			log.Println("Partial Solution:")
-			for _, s := range solution {
-				log.Printf("\t%s\n", s)
-			}
+		if len(unprocessed) == 0 {
+			// Saturation: no new sequent can be derived
+			break
 		}
-		if len(reduced) < 1 {
-			log.Println("Reduced list is empty")
-		} else {
-			log.Println("Reduced:")
-			for _, s := range reduced {
-				log.Printf("\t%s\n", s)
-			}
+		if resolutions >= maxResolutions {
+			return solution, &LimitError{Limit: LimitResolutions, Value: maxResolutions}
 		}
-	}
 
-	if len(reduced) > 1 {
-		rule := p.ResolutionRule
-		res, err := rule.applyRuleTo(&reduced)
-		if err != nil {
-			return solution, err
+		given := unprocessed[0]
+		unprocessed = unprocessed[1:]
+		k := sequentKey(given)
+		if seen[k] || given.subsumedBy(processed) {
+			continue
 		}
-		if len(res) > 0 {
-			if p.Debug {
-				log.Printf("Rule %s was applied on %s\n", rule.getName(), reduced)
-			}
-			s := res[2]
-			// The rule was applied successfully
-			i = i + 1
-			s.Name = fmt.Sprintf("S%d", i)
+		seen[k] = true
+		processed = append(processed, given)
 
-			if p.Debug {
-				log.Printf("New sequent is %s\n", s)
+		rule := p.ResolutionRule
+		for _, other := range processed {
+			pairs := [][2]*Sequent{{given, other}}
+			if other != given {
+				pairs = append(pairs, [2]*Sequent{other, given})
 			}
-
-			if len(s.Left) == 0 && len(s.Right) == 0 {
-				// A solution was found
-				solution = append(solution, res...)
-				return solution, nil
+			for _, pair := range pairs {
+				res, err := rule.applyRuleTo(pair[0], pair[1])
+				if err != nil {
+					return solution, err
+				}
+				for _, s := range res {
+					if err := p.checkLimits(ctx, steps, i, size); err != nil {
+						return solution, err
+					}
+					s.sortFormulas()
+					formulas.internSequent(s)
+					// Copies of a formula are merged and resolvents subsumed by a reduced sequent are dropped,
+					// so that deriving the same sequent again does not keep the search going
+					s.merge()
+					if s.subsumedBy(processed, unprocessed) {
+						continue
+					}
+					resolutions = resolutions + 1
+					steps = steps + 1
+					i = i + 1
+					s.Name = fmt.Sprintf("S%d", i)
+					sequents[s.Name] = s
+					size = size + s.size()
+					if p.Debug {
+						log.Printf("Rule %s was applied on %s and %s\n", rule.getName(), pair[0], pair[1])
+						log.Printf("New sequent is %s\n", s)
+					}
+					if len(s.Left) == 0 && len(s.Right) == 0 {
+						// A solution was found
+						return appendDerivation(solution, s, sequents), nil
+					}
+					unreduced = append(unreduced, s)
+					unreduced = append(unreduced, addAxioms(s)...)
+				}
 			}
 		}
 	}
 
-	if p.Debug {
-		log.Println("******************************")
-		log.Printf("******* %s was applied *******\n", p.ResolutionRule.getName())
-		log.Println("******************************")
-		log.Println("Unreduced:")
-		for _, u := range unreduced {
-			log.Printf("\t%s\n", u)
-		}
-		if len(solution) < 1 {
-			log.Println("Solution is empty")
-		} else {
-			log.Println("Partial Solution:")
-			for _, s := range solution {
-				log.Printf("\t%s\n", s)
-			}
-		}
-		if len(reduced) < 1 {
-			log.Println("Reduced list is empty")
-		} else {
-			log.Println("Reduced:")
-			for _, s := range reduced {
-				log.Printf("\t%s\n", s)
-			}
-		}
-	}
+	p.logState(fmt.Sprintf("%s saturated", p.ResolutionRule.getName()), unreduced, solution, processed)
 
-	return solution, fmt.Errorf("No solution found")
+	return solution, ErrNoSolution
 }
 
-// Prove givent a set of formulas it output a solution, if debugOn is true debugging messages will be printed
-func (p *Prover) Prove(rf *RawFormula) ([]*Sequent, error) {
-	p.initProver()
+// read parses a formula as it is written
+func (p *Prover) read(rf *RawFormula) (*formula, error) {
+	err := p.initProver()
+	if err != nil {
+		return nil, err
+	}
 	if p.Debug {
 		log.Println("Input:")
 		log.Printf("\t%s\n", rf.Formula)
 	}
-	tokens, err := tokenize(strings.Replace(rf.Formula, " ", "", -1), 0x00)
+	tokens, err := tokenize(rf.Formula, p.Syntax)
+	err = located(rf.Formula, err)
 	if p.Debug {
 		log.Println("Tokens:")
 		for i := len(tokens) - 1; i >= 0; i-- {
 			t := tokens[i]
-			if len(t.Vars) == 0 {
+			if len(t.Args) == 0 {
 				log.Printf("\t%d: %s\n", len(tokens)-i, t.Value)
 			} else {
-				log.Printf("\t%d: %s Vars: %s\n", len(tokens)-i, t.Value, t.Vars)
+				log.Printf("\t%d: %s Args: %s\n", len(tokens)-i, t.Value, termArrayToString(t.Args))
 			}
 		}
 	}
@@ -603,14 +1013,73 @@ func (p *Prover) Prove(rf *RawFormula) ([]*Sequent, error) {
 		return nil, err
 	}
 	top, err := genFormulasTree(tokens)
+	return top, located(rf.Formula, err)
+}
+
+// reduce rewrites a formula with the connectives handled by the rules of the prover
+func (p *Prover) reduce(top *formula) *formula {
+	if p.Native {
+		top = pushNext(top)
+	} else {
+		top = reduceFormulas(top)
+	}
 	if p.Debug {
 		log.Println("Formula:")
 		log.Printf("\t%s\n", top)
 	}
+	return top
+}
+
+// parse reads a formula and turns it into the normal form of the prover
+func (p *Prover) parse(rf *RawFormula) (*formula, error) {
+	top, err := p.read(rf)
+	if err != nil {
+		return nil, err
+	}
+	pr := &problem{Goal: top}
+	p.normalize(pr)
+	return p.reduce(pr.Goal), nil
+}
+
+// parseProblem parses the goal, the premises and the axioms of the prover
+func (p *Prover) parseProblem(rf *RawFormula) (*problem, error) {
+	top, err := p.read(rf)
 	if err != nil {
 		return nil, err
 	}
-	s, err := p.proveFormula(top)
+	pr := &problem{Goal: top, Premises: []*formula{}, Axioms: []*formula{}}
+	for _, h := range p.Premises {
+		f, err := p.read(h)
+		if err != nil {
+			return nil, fmt.Errorf("premise %s: %w", h.Formula, err)
+		}
+		pr.Premises = append(pr.Premises, f)
+	}
+	for _, a := range p.Axioms {
+		f, err := p.read(a)
+		if err != nil {
+			return nil, fmt.Errorf("axiom %s: %w", a.Formula, err)
+		}
+		pr.Axioms = append(pr.Axioms, f)
+	}
+	p.normalize(pr)
+	pr.Goal = p.reduce(pr.Goal)
+	for i, h := range pr.Premises {
+		pr.Premises[i] = p.reduce(h)
+	}
+	for i, a := range pr.Axioms {
+		pr.Axioms[i] = p.reduce(a)
+	}
+	return pr, nil
+}
+
+func (p *Prover) prove(ctx context.Context, pr *problem) ([]*Sequent, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	s, err := p.proveFormula(ctx, pr)
 	if p.Debug {
 		log.Println("Sequents:")
 		for _, Sequent := range s {
@@ -623,6 +1092,52 @@ func (p *Prover) Prove(rf *RawFormula) ([]*Sequent, error) {
 	return s, nil
 }
 
+// Prove givent a set of formulas it output a solution, if debugOn is true debugging messages will be printed
+func (p *Prover) Prove(rf *RawFormula) ([]*Sequent, error) {
+	return p.ProveContext(context.Background(), rf)
+}
+
+// ProveContext works like Prove, but the search is stopped when ctx is done.
+// When a limit stops the search a *LimitError is returned together with the partial result
+func (p *Prover) ProveContext(ctx context.Context, rf *RawFormula) ([]*Sequent, error) {
+	pr, err := p.parseProblem(rf)
+	if err != nil {
+		return nil, err
+	}
+	return p.prove(ctx, pr)
+}
+
+// ProveOrRefute works like Prove, but when no solution is found it also looks for a countermodel
+// built from the worlds named during the search. The countermodel is nil if none was found,
+// it is always nil when a frame includes the relation of another agent, like in LTL
+func (p *Prover) ProveOrRefute(rf *RawFormula) ([]*Sequent, *Countermodel, error) {
+	return p.ProveOrRefuteContext(context.Background(), rf)
+}
+
+// ProveOrRefuteContext works like ProveOrRefute, but the search for a proof and the one for a countermodel
+// are stopped when ctx is done. The timeout of the prover bounds the two searches together
+func (p *Prover) ProveOrRefuteContext(ctx context.Context, rf *RawFormula) ([]*Sequent, *Countermodel, error) {
+	pr, err := p.parseProblem(rf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	s, err := p.prove(ctx, pr)
+	if err != nil {
+		m := p.findCountermodel(ctx, pr)
+		if p.Debug && m != nil {
+			log.Println("Countermodel:")
+			log.Printf("\t%s\n", m)
+		}
+		return s, m, err
+	}
+	return s, nil, nil
+}
+
 // EncodeSequentSlice returns a map of latex encoded sequnets
 func EncodeSequentSlice(in []*Sequent) (*map[int]RawSequent, error) {
 	rawSolution := make(map[int]RawSequent)
@@ -1,11 +1,15 @@
 package moltp
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestReduceORFormula(t *testing.T) {
@@ -41,7 +45,7 @@ func TestEncodeSequentSlice(t *testing.T) {
 	A := &formula{Terminal: "A"}
 	B := &formula{Terminal: "B"}
 
-	g0 := &formula{Terminal: sFORALL, Operands: []*formula{&formula{Terminal: "x"}, &formula{Terminal: "f", Vars: []string{"x"}}}}
+	g0 := &formula{Terminal: sFORALL, Operands: []*formula{&formula{Terminal: "x"}, &formula{Terminal: "f", Args: []*term{&term{Value: "x", IsVar: true}}}}}
 	g1 := &formula{Terminal: sIMPLY, Operands: []*formula{A, g0}}
 	g2 := &formula{Terminal: sNOT, Operands: []*formula{g1}}
 
@@ -60,7 +64,7 @@ func TestEncodeSequentSlice(t *testing.T) {
 		t.Errorf("got error %s want nil", err)
 	} else {
 		out := []string{
-			"{S1 ( \\lnot ( A \\to ( \\forall ( x ) f(x) ) ) ) ( \\Box ( A \\to ( \\lnot B ) ) ) R11, S0}",
+			"{S1 \\lnot ( A \\to \\forall x\\, f(x) ) \\Box ( A \\to \\lnot B ) R11, S0}",
 		}
 		for i, o := range out {
 			s := fmt.Sprintf("%s", (*encoded)[i])
@@ -76,7 +80,7 @@ func TestProver1(t *testing.T) {
 	// logging to temp file so that we cannot see it
 	w, _ := os.Open("/tmp/test.log")
 	log.SetOutput(w)
-	prover := Prover{Debug: true}
+	prover := Prover{Debug: true, System: SystemS4}
 	solution, err := prover.Prove(rf)
 	if err != nil {
 		t.Errorf("got error %s want nil", err)
@@ -113,13 +117,13 @@ func TestProver2(t *testing.T) {
 			"S4: |( Box a )|_{w:0} <-  [R8 S3]",
 			"S2:  <- |( Not ( Box ( Not ( Not ( Box ( Not a ) ) ) ) ) )|_{0} [R3 S1]",
 			"S6: |( Box ( Not ( Not ( Box ( Not a ) ) ) ) )|_{0} <-  [R6 S2]",
-			"S7: |( Box ( Not a ) )|_{u:0} <-  [R8 S6]",
+			"S7: |( Not ( Not ( Box ( Not a ) ) ) )|_{u:0} <-  [R8 S6]",
 			"S8:  <- |( Not ( Box ( Not a ) ) )|_{u:0} [R5 S7]",
 			"S9: |( Box ( Not a ) )|_{u:0} <-  [R6 S8]",
 			"S10: |( Not a )|_{w':u:0} <-  [R8 S9]",
 			"S5: |a|_{v:w:0} <-  [R8 S4]",
 			"S11:  <- |a|_{w':u:0} [R5 S10]",
-			"S12:  <-  [R1 S5 S11]",
+			"S12:  <-  [R1 S5 S11 {v/w',w/u}]",
 		}
 		for i, o := range out {
 			s := fmt.Sprintf("%s", solution[i])
@@ -133,14 +137,14 @@ func TestProver2(t *testing.T) {
 
 func TestProver3(t *testing.T) {
 	rf := &RawFormula{OID: 0, Formula: "\\Diamond \\Box a \\to \\Box \\Diamond a"}
-	prover := Prover{Debug: false}
+	prover := Prover{Debug: false, System: SystemS5}
 	solution, err := prover.Prove(rf)
 	if err != nil {
 		t.Errorf("got error %s want nil", err)
 	} else {
 		out := []string{
 			"S1:  <- |( ( Not ( Box ( Not ( Box a ) ) ) ) Implies ( Box ( Not ( Box ( Not a ) ) ) ) )|_{0} []",
-			"S3: |( Box a )|_{1:0} <-  [R4 S1]",
+			"S3: |( Not ( Box ( Not ( Box a ) ) ) )|_{0} <-  [R4 S1]",
 			"S4:  <- |( Box ( Not ( Box a ) ) )|_{0} [R5 S3]",
 			"S5:  <- |( Not ( Box a ) )|_{1:0} [R7 S4]",
 			"S6: |( Box a )|_{1:0} <-  [R6 S5]",
@@ -213,3 +217,1215 @@ func TestProver5(t *testing.T) {
 		}
 	}
 }
+
+// systemCase is a formula and whether it is proved in a system
+type systemCase struct {
+	system  string
+	formula string
+	proved  bool
+}
+
+// systemCases returns the formulas proved, or not, in each system
+func systemCases() []systemCase {
+	cases := []systemCase{
+		{SystemK, "\\Box a \\to \\Diamond a", false},
+		{SystemD, "\\Box a \\to \\Diamond a", true},
+		{SystemD, "\\Box a \\to a", false},
+		{SystemT, "\\Box a \\to a", true},
+		{SystemT, "\\Box a \\to \\Box \\Box a", false},
+		{SystemT, "\\Box \\Box a \\to a", true},
+		{SystemT, "\\Box \\Box \\Box a \\to \\Box a", true},
+		{SystemS4, "\\Box \\Box a \\to a", true},
+		{SystemS4, "\\Box \\Box \\Box a \\to \\Box a", true},
+		{SystemK4, "\\Box a \\to \\Box \\Box a", true},
+		{SystemS4, "\\Diamond a \\to \\Box \\Diamond a", false},
+		{SystemK5, "\\Diamond a \\to \\Box \\Diamond a", true},
+		{SystemK, "a \\to \\Box \\Diamond a", false},
+		{SystemKB, "a \\to \\Box \\Diamond a", true},
+		{SystemKD45, "\\Box a \\to a", false},
+		{SystemS5, "\\Diamond a \\to \\Box \\Diamond a", true},
+		{SystemK, "\\Box \\Diamond a \\to \\Diamond a", false},
+		{SystemD, "\\Box \\Diamond a \\to \\Diamond a", false},
+		{SystemT, "\\Box \\Diamond a \\to \\Diamond a", true},
+		{SystemB, "\\Box \\Diamond a \\to \\Diamond a", true},
+		{SystemK4, "\\Box \\Diamond a \\to \\Diamond a", false},
+		{SystemS4, "\\Box \\Diamond a \\to \\Diamond a", true},
+		{SystemKD45, "\\Box \\Diamond a \\to \\Diamond a", true},
+		{SystemS5, "\\Box \\Diamond a \\to \\Diamond a", true},
+	}
+	// Worlds reached by a skolem function of a world variable are unified with the ones of a known world
+	for _, system := range []string{SystemK, SystemD, SystemT, SystemB, SystemKB, SystemK4, SystemS4, SystemK5, SystemKD45, SystemS5} {
+		cases = append(cases, []systemCase{
+			{system, "\\Box \\Diamond a \\to \\Box \\Diamond a", true},
+			{system, "\\Diamond \\Box a \\to \\Diamond \\Box a", true},
+		}...)
+	}
+	return cases
+}
+
+func TestProverSystems(t *testing.T) {
+	for _, c := range systemCases() {
+		for _, native := range []bool{false, true} {
+			prover := Prover{System: c.system, Native: native}
+			_, err := prover.Prove(&RawFormula{Formula: c.formula})
+			if c.proved && err != nil {
+				t.Errorf("%s native %t: got error %s want nil for %s", c.system, native, err, c.formula)
+			}
+			if !c.proved && err == nil {
+				t.Errorf("%s native %t: got a solution want an error for %s", c.system, native, c.formula)
+			}
+		}
+	}
+}
+
+func TestProverSystemsNormalForms(t *testing.T) {
+	// Each normal form proves the formulas proved without one
+	for _, form := range []string{NormalFormNNF, NormalFormCNF, NormalFormModalCNF, NormalFormRenamed} {
+		for _, c := range systemCases() {
+			for _, native := range []bool{false, true} {
+				prover := Prover{System: c.system, Native: native, NormalForm: form, Timeout: 10 * time.Second}
+				_, err := prover.Prove(&RawFormula{Formula: c.formula})
+				if c.proved && err != nil {
+					t.Errorf("%s %s native %t: got error %s want nil for %s", form, c.system, native, err, c.formula)
+				}
+				if !c.proved && err == nil {
+					t.Errorf("%s %s native %t: got a solution want an error for %s", form, c.system, native, c.formula)
+				}
+			}
+		}
+	}
+}
+
+func TestProverFrame(t *testing.T) {
+	rf := &RawFormula{OID: 0, Formula: "\\Box a \\to a"}
+	prover := Prover{Frame: &Frame{Reflexive: true}}
+	_, err := prover.Prove(rf)
+	if err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+
+	prover = Prover{System: "S3"}
+	_, err = prover.Prove(rf)
+	if err == nil {
+		t.Errorf("got nil want an error for an unknown system")
+	}
+
+	// The system is read again by each call
+	prover = Prover{System: SystemK}
+	if _, err = prover.Prove(rf); err == nil {
+		t.Errorf("got nil want an error in K")
+	}
+	prover.System = SystemT
+	if _, err = prover.Prove(rf); err != nil {
+		t.Errorf("got error %s want nil after changing the system to T", err)
+	}
+	prover.System = ""
+	prover.Frame = &Frame{}
+	if _, err = prover.Prove(rf); err == nil {
+		t.Errorf("got nil want an error after changing the frame")
+	}
+}
+
+func TestTokenizeIdentifiers(t *testing.T) {
+	cases := map[string]string{
+		"rain \\to wet":                        "( rain Implies wet )",
+		"p_1 \\land p'_2":                      "( Not ( p_1 Implies ( Not p'_2 ) ) )",
+		"Likes(alice, bob) \\lor Likes(bob,x)": "( ( Not Likes(alice,bob) ) Implies Likes(bob,x) )",
+		"\\forall person Likes(person,bob)":    "( Forall ( person ) Likes(person,bob) )",
+		"\\Box p_{1}":                          "( Box |p|_{1} )",
+	}
+	for in, want := range cases {
+		tokens, err := tokenize(in, SyntaxTeX)
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, in)
+			continue
+		}
+		f, err := genFormulasTree(tokens)
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, in)
+			continue
+		}
+		f = reduceFormulas(f)
+		if fmt.Sprint(f) != want {
+			t.Errorf("got %s want %s", f, want)
+		}
+	}
+
+	for _, in := range []string{"p(a,) \\to p", "p(a \\to p", "p(a b)"} {
+		_, err := tokenize(in, SyntaxTeX)
+		if err == nil {
+			t.Errorf("got nil want an error for %s", in)
+		}
+	}
+}
+
+func TestUnify(t *testing.T) {
+	x := &term{Value: "x", IsVar: true}
+	y := &term{Value: "y", IsVar: true}
+	a := &term{Value: "a"}
+	b := &term{Value: "b"}
+	f := func(args ...*term) *term { return &term{Value: "f", Args: args} }
+	g := func(args ...*term) *term { return &term{Value: "g", Args: args} }
+
+	cases := []struct {
+		left  []*term
+		right []*term
+		want  string
+	}{
+		{[]*term{x, b}, []*term{a, y}, "{x/a,y/b}"},
+		{[]*term{f(g(x))}, []*term{f(y)}, "{y/g(x)}"},
+		{[]*term{f(x), x}, []*term{f(g(y)), g(a)}, "{x/g(a),y/a}"},
+		{[]*term{x, x}, []*term{a, b}, ""},
+		{[]*term{f(x)}, []*term{g(x)}, ""},
+		{[]*term{x, f(x)}, []*term{y, y}, ""},
+		{[]*term{x}, []*term{x, y}, ""},
+	}
+	for _, c := range cases {
+		u := unify(c.left, c.right)
+		if c.want == "" {
+			if u != nil {
+				t.Errorf("got %s want nil for %s and %s", u, termArrayToString(c.left), termArrayToString(c.right))
+			}
+			continue
+		}
+		if u == nil {
+			t.Errorf("got nil want %s for %s and %s", c.want, termArrayToString(c.left), termArrayToString(c.right))
+		} else if fmt.Sprint(u) != c.want {
+			t.Errorf("got %s want %s", u, c.want)
+		}
+	}
+}
+
+func TestProverFirstOrder(t *testing.T) {
+	cases := map[string]bool{
+		"(\\forall x Likes(x,bob)) \\to Likes(alice,bob)":            true,
+		"(\\forall x Likes(x,bob)) \\to Likes(alice,carol)":          false,
+		"(\\forall x p(f(x))) \\to p(f(g(a)))":                       true,
+		"(\\forall x p(x,f(x))) \\to (\\forall y p(y,y))":            false,
+		"(\\forall x \\forall y p(x,y)) \\to p(a,b)":                 true,
+		"(\\forall x \\Box p(x)) \\to \\Box (\\forall y p(y))":       true,
+		"(\\forall x \\Box p(x, x)) \\to \\Box (\\forall y p(y, a))": false,
+	}
+	for in, proved := range cases {
+		prover := Prover{}
+		_, err := prover.Prove(&RawFormula{Formula: in})
+		if proved && err != nil {
+			t.Errorf("got error %s want nil for %s", err, in)
+		}
+		if !proved && err == nil {
+			t.Errorf("got a solution want an error for %s", in)
+		}
+	}
+}
+
+func TestProverResolutionLoop(t *testing.T) {
+	rf := &RawFormula{OID: 0, Formula: "\\Box ( a \\to b ) \\to ( \\Box a \\to \\Box b )"}
+	prover := Prover{System: SystemK}
+	solution, err := prover.Prove(rf)
+	if err != nil {
+		t.Errorf("got error %s want nil", err)
+	} else {
+		out := []string{
+			"S9:  <- |b|_{1:0} [R7 S6]",
+			"S10:  <- |a|_{1:0} [R1 S5 S9 {w/1}]",
+			"S11:  <-  [R1 S8 S10 {v/1}]",
+		}
+		for i, o := range out {
+			s := fmt.Sprintf("%s", solution[len(solution)-len(out)+i])
+			if o != s {
+				t.Errorf("got %s want %s", s, o)
+			}
+		}
+	}
+
+	rf = &RawFormula{OID: 0, Formula: "(a \\to b) \\to (b \\to a)"}
+	prover = Prover{}
+	_, err = prover.Prove(rf)
+	if err == nil || err.Error() != "No solution found" {
+		t.Errorf("got %v want No solution found", err)
+	}
+
+	rf = &RawFormula{OID: 0, Formula: "(a \\to b) \\to ((b \\to c) \\to (a \\to c))"}
+	prover = Prover{MaxResolutions: 1}
+	_, err = prover.Prove(rf)
+	if err == nil {
+		t.Errorf("got nil want an error when the resolution limit is reached")
+	}
+	prover = Prover{}
+	_, err = prover.Prove(rf)
+	if err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+
+	// Resolution removes all the copies of the resolved atom
+	if _, err := prover.Prove(&RawFormula{Formula: "p \\iff p"}); err != nil {
+		t.Errorf("got error %s want nil for p \\iff p", err)
+	}
+
+	// The loop saturates: the same principal gets the same new world, copies are factored
+	// and subsumed resolvents are dropped, so biconditionals do not reach the resolution limit
+	for _, native := range []bool{false, true} {
+		prover = Prover{System: SystemK, Native: native}
+		for _, goal := range []string{
+			"\\Box (a \\land b) \\iff (\\Box a \\land \\Box b)",
+			"\\Diamond (a \\lor b) \\iff (\\Diamond a \\lor \\Diamond b)",
+			"(\\lnot \\Diamond a) \\iff (\\Box \\lnot a)",
+		} {
+			proveAndCheck(t, &prover, &Prover{System: SystemK, Native: native}, goal, true)
+		}
+		// The worlds given in a search are not reused by the next one
+		if _, err := prover.Prove(&RawFormula{Formula: "p \\iff p"}); err != nil {
+			t.Errorf("got error %s want nil for p \\iff p", err)
+		}
+		if len(prover.worldsKeeper.worlds) != 0 || len(prover.worldsKeeper.terms) != 0 {
+			t.Errorf("got %d worlds and %d terms want none left from the previous search", len(prover.worldsKeeper.worlds), len(prover.worldsKeeper.terms))
+		}
+	}
+
+	// Nested modalities need new worlds of skolem worlds and variables bound above them
+	for _, native := range []bool{false, true} {
+		for _, c := range []struct {
+			system string
+			goal   string
+		}{
+			{SystemT, "\\Box \\Diamond \\Box a \\to \\Diamond \\Box a"},
+			{SystemT, "\\Box \\lnot \\Box a \\to \\lnot \\Box a"},
+			{SystemS4, "\\Box \\Diamond \\Box \\Diamond a \\to \\Box \\Diamond a"},
+		} {
+			prover = Prover{System: c.system, Native: native}
+			proveAndCheck(t, &prover, &Prover{System: c.system, Native: native}, c.goal, true)
+		}
+		prover = Prover{System: SystemK, Native: native}
+		proveAndCheck(t, &prover, &Prover{System: SystemK, Native: native}, "\\Box \\Diamond \\Box a \\to \\Diamond \\Box a", false)
+	}
+}
+
+func TestProveOrRefute(t *testing.T) {
+	rf := &RawFormula{OID: 0, Formula: "\\Box a \\to a"}
+	prover := Prover{System: SystemD}
+	_, model, err := prover.ProveOrRefute(rf)
+	if err == nil {
+		t.Errorf("got nil want an error")
+	}
+	if model == nil {
+		t.Fatalf("got nil want a countermodel")
+	}
+	out := "Worlds: 0, w:0; Relation: 0 -> w:0, w:0 -> w:0; Valuation: 0: {}, w:0: {a}"
+	if s := fmt.Sprintf("%s", model); s != out {
+		t.Errorf("got %s want %s", s, out)
+	}
+
+	prover = Prover{System: SystemT}
+	_, model, err = prover.ProveOrRefute(rf)
+	if err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+	if model != nil {
+		t.Errorf("got %s want nil", model)
+	}
+
+	rf = &RawFormula{OID: 0, Formula: "\\forall x P(x) \\to P(c)"}
+	prover = Prover{}
+	_, model, _ = prover.ProveOrRefute(rf)
+	if model != nil {
+		t.Errorf("got %s want nil for a first order formula", model)
+	}
+
+	// The search for a countermodel stops when the context is done
+	prover = Prover{System: SystemK}
+	pr, err := prover.parseProblem(&RawFormula{OID: 0, Formula: "\\Box a \\to a"})
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	if _, err := prover.prove(context.Background(), pr); err == nil {
+		t.Fatalf("got nil want an error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if model := prover.findCountermodel(ctx, pr); model != nil {
+		t.Errorf("got %s want nil when the context is done", model)
+	}
+	if model := prover.findCountermodel(context.Background(), pr); model == nil {
+		t.Errorf("got nil want a countermodel")
+	}
+}
+
+func TestProverLimits(t *testing.T) {
+	rf := &RawFormula{OID: 0, Formula: "(a \\to b) \\to ((b \\to c) \\to (a \\to c))"}
+	provers := map[string]Prover{
+		LimitSteps:    {MaxSteps: 2},
+		LimitSequents: {MaxSequents: 3},
+		LimitSize:     {MaxSize: 12},
+	}
+	for limit, prover := range provers {
+		solution, err := prover.Prove(rf)
+		lerr, ok := err.(*LimitError)
+		if !ok {
+			t.Errorf("got %v want a LimitError", err)
+			continue
+		}
+		if lerr.Limit != limit {
+			t.Errorf("got %s want %s", lerr.Limit, limit)
+		}
+		if len(solution) == 0 {
+			t.Errorf("got an empty partial result for the %s limit", limit)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	prover := Prover{}
+	_, err := prover.ProveContext(ctx, rf)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v want %s", err, context.Canceled)
+	}
+
+	prover = Prover{Timeout: time.Nanosecond}
+	_, err = prover.ProveContext(context.Background(), rf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v want %s", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBuildProof(t *testing.T) {
+	rf := &RawFormula{OID: 0, Formula: "\\Box ( a \\to b ) \\to ( \\Box a \\to \\Box b )"}
+	prover := Prover{System: SystemK}
+	proof, err := prover.BuildProof(context.Background(), rf)
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	if proof.Goal == nil || proof.Goal.ID != "S11" {
+		t.Fatalf("got %v want S11 as goal", proof.Goal)
+	}
+	if proof.Goal.Rule != "R1" || len(proof.Goal.Premises) != 2 {
+		t.Errorf("got %s with %d premises want R1 with 2 premises", proof.Goal.Rule, len(proof.Goal.Premises))
+	}
+	if v := proof.Goal.Substitution["v"]; v != "1" {
+		t.Errorf("got %s want 1", v)
+	}
+	if got := fmt.Sprintf("%s %s %s", proof.Goal.Principal, proof.Goal.Complement, proof.Goal.Worlds["v"]); got != "|a|_{v:0} |a|_{1:0} 1:0" {
+		t.Errorf("got %s want |a|_{v:0} |a|_{1:0} 1:0", got)
+	}
+	s := proof.Step("S9")
+	if s == nil || s.Rule != "R7" || s.Principal != "|( Box b )|_{0}" {
+		t.Errorf("got %v want S9 derived by R7 from |( Box b )|_{0}", s)
+	}
+
+	path := []string{}
+	for _, k := range proof.PathToRoot(proof.Goal) {
+		path = append(path, k.ID)
+	}
+	if p := strings.Join(path, " "); !strings.HasSuffix(p, "S1") || !strings.HasPrefix(p, "S11 S8") {
+		t.Errorf("got %s want a path from S11 to S1", p)
+	}
+
+	visited := 0
+	proof.Walk(func(k *ProofStep) bool {
+		visited = visited + 1
+		return true
+	})
+	if d := proof.Derivation(); visited != len(d) || d[0] != proof.Root || d[len(d)-1] != proof.Goal {
+		t.Errorf("got %d visited steps and %d derivation steps", visited, len(d))
+	}
+
+	rf = &RawFormula{OID: 0, Formula: "a \\to b"}
+	proof, err = prover.BuildProof(context.Background(), rf)
+	if err == nil || proof == nil || proof.Goal != nil || proof.Root == nil {
+		t.Errorf("got %v, %v want a partial proof and an error", proof, err)
+	}
+}
+
+func TestCheckProof(t *testing.T) {
+	formulas := map[string]string{
+		SystemK:  "\\Box ( a \\to b ) \\to ( \\Box a \\to \\Box b )",
+		SystemS4: "\\Box a \\to \\Box \\Box a",
+		SystemD:  "(\\forall x \\Box p(x)) \\to \\Box (\\forall y p(y))",
+	}
+	for system, f := range formulas {
+		rf := &RawFormula{OID: 0, Formula: f}
+		prover := Prover{System: system}
+		proof, err := prover.BuildProof(context.Background(), rf)
+		if err != nil {
+			t.Errorf("got error %s want nil", err)
+			continue
+		}
+		data, err := json.Marshal(proof)
+		if err != nil {
+			t.Errorf("got error %s want nil", err)
+			continue
+		}
+		stored := &Proof{}
+		err = json.Unmarshal(data, stored)
+		if err != nil {
+			t.Errorf("got error %s want nil", err)
+			continue
+		}
+		checker := Prover{System: system}
+		err = checker.CheckProof(rf, stored)
+		if err != nil {
+			t.Errorf("%s: got error %s want nil", f, err)
+		}
+	}
+
+	rf := &RawFormula{OID: 0, Formula: formulas[SystemK]}
+	prover := Prover{System: SystemK}
+	proof, _ := prover.BuildProof(context.Background(), rf)
+
+	// The same proof is not valid for another formula
+	checker := Prover{System: SystemK}
+	err := checker.CheckProof(&RawFormula{OID: 0, Formula: "\\Box a \\to \\Box b"}, proof)
+	if perr, ok := err.(*ProofError); !ok || perr.Step != "S1" {
+		t.Errorf("got %v want a bad step S1", err)
+	}
+
+	// The world bindings of a resolution step must be admissible in the frame
+	rf = &RawFormula{OID: 0, Formula: "\\Box a \\to a"}
+	prover = Prover{System: SystemT}
+	proof, _ = prover.BuildProof(context.Background(), rf)
+	if err := checker.CheckProof(rf, proof); err != nil {
+		t.Errorf("got error %s want nil checking with the settings of the proof", err)
+	}
+	proof.Settings.System = SystemK
+	err = checker.CheckProof(rf, proof)
+	if perr, ok := err.(*ProofError); !ok || perr.Step != proof.Goal.ID {
+		t.Errorf("got %v want a bad step %s in K", err, proof.Goal.ID)
+	}
+	// A proof without settings is checked with the ones of the checker
+	proof.Settings = nil
+	err = checker.CheckProof(rf, proof)
+	if perr, ok := err.(*ProofError); !ok || perr.Step != proof.Goal.ID {
+		t.Errorf("got %v want a bad step %s in K", err, proof.Goal.ID)
+	}
+
+	// A resolution step must use the substitution it records
+	proof, _ = prover.BuildProof(context.Background(), rf)
+	proof.Goal.Substitution = map[string]string{"w": "1"}
+	err = (&Prover{System: SystemT}).CheckProof(rf, proof)
+	if perr, ok := err.(*ProofError); !ok || perr.Step != proof.Goal.ID {
+		t.Errorf("got %v want a bad step %s", err, proof.Goal.ID)
+	}
+
+	rf = &RawFormula{OID: 0, Formula: formulas[SystemK]}
+	prover = Prover{System: SystemK}
+	proof, _ = prover.BuildProof(context.Background(), rf)
+
+	// Removing a formula from a step breaks it and the steps after it are not checked
+	s := proof.Step("S9")
+	s.Sequent.Right = []*formula{}
+	err = checker.CheckProof(rf, proof)
+	if perr, ok := err.(*ProofError); !ok || perr.Step != "S9" {
+		t.Errorf("got %v want a bad step S9", err)
+	}
+
+	// A rule rewriting a connective must put the operands of the principal on the sides stated by the rule
+	proof, _ = prover.BuildProof(context.Background(), rf)
+	for _, k := range proof.Steps {
+		if k.Rule == "R4" {
+			k.Sequent.Left, k.Sequent.Right = k.Sequent.Right, k.Sequent.Left
+			err = checker.CheckProof(rf, proof)
+			if perr, ok := err.(*ProofError); !ok || perr.Step != k.ID {
+				t.Errorf("got %v want a bad step %s", err, k.ID)
+			}
+			break
+		}
+	}
+
+	// The premises are stored with the proof
+	prover = Prover{Premises: []*RawFormula{{OID: 1, Formula: "\\Box ( a \\to b )"}}}
+	rf = &RawFormula{OID: 0, Formula: "\\Box a \\to \\Box b"}
+	proof, _ = prover.BuildProof(context.Background(), rf)
+	data, _ := json.Marshal(proof)
+	stored := &Proof{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	if err := (&Prover{}).CheckProof(rf, stored); err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+}
+
+func TestProverPremises(t *testing.T) {
+	cases := []struct {
+		premises []string
+		goal     string
+		proved   bool
+	}{
+		{[]string{"a \\to b", "a"}, "b", true},
+		{[]string{"\\Box ( a \\to b )", "\\Box a"}, "\\Box b", true},
+		{[]string{"a \\to b"}, "b", false},
+		{[]string{"a"}, "\\Box a", false},
+		{[]string{"\\forall x P(x)"}, "P(c)", true},
+	}
+	for _, c := range cases {
+		prover := Prover{}
+		for i, h := range c.premises {
+			prover.Premises = append(prover.Premises, &RawFormula{OID: i + 1, Formula: h})
+		}
+		rf := &RawFormula{OID: 0, Formula: c.goal}
+		proof, err := prover.BuildProof(context.Background(), rf)
+		if c.proved && err != nil {
+			t.Errorf("got error %s want nil for %v |- %s", err, c.premises, c.goal)
+		}
+		if !c.proved && err == nil {
+			t.Errorf("got a solution want an error for %v |- %s", c.premises, c.goal)
+		}
+		if proof == nil || proof.Root == nil || proof.Root.ID != "S1" {
+			t.Errorf("got %v want a proof rooted in S1", proof)
+			continue
+		}
+		if c.proved {
+			checker := Prover{Premises: prover.Premises}
+			err = checker.CheckProof(rf, proof)
+			if err != nil {
+				t.Errorf("got error %s want nil", err)
+			}
+		}
+	}
+
+	prover := Prover{Premises: []*RawFormula{{OID: 1, Formula: "a"}}}
+	_, model, _ := prover.ProveOrRefute(&RawFormula{OID: 0, Formula: "\\Box a"})
+	out := "Worlds: 0, 1:0; Relation: 0 -> 1:0, 1:0 -> 1:0; Valuation: 0: {a}, 1:0: {}"
+	if model == nil {
+		t.Errorf("got nil want %s", out)
+	} else if s := fmt.Sprintf("%s", model); s != out {
+		t.Errorf("got %s want %s", s, out)
+	}
+}
+
+// proveAndCheck looks for a proof of the goal with prover, a proof it finds must be accepted by checker
+func proveAndCheck(t *testing.T, prover, checker *Prover, goal string, proved bool) {
+	t.Helper()
+	rf := &RawFormula{OID: 0, Formula: goal}
+	proof, err := prover.BuildProof(context.Background(), rf)
+	if proved && err != nil {
+		t.Errorf("got error %s want nil for %s", err, goal)
+	}
+	if !proved && err == nil {
+		t.Errorf("got a solution want an error for %s", goal)
+	}
+	if proved && err == nil {
+		if err := checker.CheckProof(rf, proof); err != nil {
+			t.Errorf("got error %s want nil checking the proof of %s", err, goal)
+		}
+	}
+}
+
+func TestProverAxioms(t *testing.T) {
+	cases := []struct {
+		axioms []string
+		goal   string
+		proved bool
+	}{
+		{[]string{"p \\to \\Box p"}, "p \\to \\Box \\Box p", true},
+		{[]string{}, "p \\to \\Box \\Box p", false},
+		{[]string{"\\Box a \\to a"}, "\\Box \\Box a \\to a", true},
+	}
+	for _, c := range cases {
+		prover := Prover{System: SystemK}
+		for i, a := range c.axioms {
+			prover.Axioms = append(prover.Axioms, &RawFormula{OID: i + 1, Formula: a})
+		}
+		proveAndCheck(t, &prover, &Prover{System: SystemK, Axioms: prover.Axioms}, c.goal, c.proved)
+	}
+
+	prover := Prover{System: SystemK, Axioms: []*RawFormula{{OID: 1, Formula: "p \\to \\Box p"}}}
+	_, model, err := prover.ProveOrRefute(&RawFormula{OID: 0, Formula: "\\Box p"})
+	if err == nil || model == nil {
+		t.Errorf("got %v, %v want a countermodel", model, err)
+	} else if len(model.Valuation[model.Root]) != 0 {
+		t.Errorf("got %v want p false in the root world", model.Valuation[model.Root])
+	}
+}
+
+func TestTokenizeAgents(t *testing.T) {
+	cases := map[string]string{
+		"\\Box_{a} p":             "( Box_{a} p )",
+		"\\Box_a p":               "( Box_{a} p )",
+		"K_{a} p \\to p":          "( ( Box_{a} p ) Implies p )",
+		"K_{1} \\to p":            "( |K|_{1} Implies p )",
+		"\\Diamond_{b} \\Box_c p": "( Not ( Box_{b} ( Not ( Box_{c} p ) ) ) )",
+	}
+	for s, want := range cases {
+		prover := Prover{}
+		f, err := prover.parse(&RawFormula{Formula: s})
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, s)
+			continue
+		}
+		if got := f.String(); got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	}
+}
+
+func TestProverAgents(t *testing.T) {
+	cases := []struct {
+		agents map[string]string
+		goal   string
+		proved bool
+	}{
+		{map[string]string{"a": SystemS5}, "K_{a} p \\to p", true},
+		{map[string]string{"a": SystemKD45}, "K_{a} p \\to p", false},
+		{map[string]string{"a": SystemKD45}, "\\Box_{a} p \\to \\Box_{a} \\Box_{a} p", true},
+		{map[string]string{"a": SystemS5, "b": SystemKD45}, "\\Box_{b} p \\to p", false},
+		{map[string]string{"a": SystemS5}, "\\Box_{a} p \\to \\Box_{b} p", false},
+		{map[string]string{"a": SystemS5}, "\\Box_{a} p \\to \\Box_{a} p", true},
+		{map[string]string{"a": SystemK}, "\\Box p \\to \\Diamond p", true},
+		{map[string]string{"a": SystemK}, "\\Box_{a} p \\to \\Diamond_{a} p", false},
+	}
+	for _, c := range cases {
+		prover := Prover{System: SystemKD45, Agents: c.agents}
+		proveAndCheck(t, &prover, &Prover{System: SystemKD45, Agents: c.agents}, c.goal, c.proved)
+	}
+
+	prover := Prover{Agents: map[string]string{"a": "S9"}}
+	if _, err := prover.Prove(&RawFormula{Formula: "\\Box_{a} p"}); err == nil {
+		t.Errorf("got nil want an error for an unknown system")
+	}
+
+	prover = Prover{System: SystemK, Agents: map[string]string{"a": SystemKD45, "b": SystemKD45}}
+	_, model, err := prover.ProveOrRefute(&RawFormula{OID: 0, Formula: "\\Box_{a} p \\to \\Box_{b} p"})
+	if err == nil || model == nil {
+		t.Errorf("got %v, %v want a countermodel", model, err)
+	} else if len(model.Agents["a"]) == 0 || len(model.Agents["b"]) == 0 {
+		t.Errorf("got %v want the relations of a and b", model.Agents)
+	}
+}
+
+func TestProverTemporal(t *testing.T) {
+	cases := []struct {
+		goal   string
+		proved bool
+	}{
+		{"\\Box p \\to \\bigcirc p", true},
+		{"\\Box p \\to \\bigcirc \\Box p", true},
+		{"\\lnot \\bigcirc p \\to \\bigcirc \\lnot p", true},
+		{"\\bigcirc \\lnot p \\to \\lnot \\bigcirc p", true},
+		{"\\bigcirc (p \\lor q) \\to \\bigcirc p \\lor \\bigcirc q", true},
+		{"\\bigcirc \\Diamond p \\to \\Diamond p", true},
+		{"\\Diamond p \\to \\bigcirc p", false},
+		{"\\bigcirc p \\to p", false},
+	}
+	for _, c := range cases {
+		proveAndCheck(t, &Prover{System: SystemLTL}, &Prover{System: SystemLTL}, c.goal, c.proved)
+	}
+
+	// The LTL system is not complete for LTL, so it reports no countermodel
+	for _, goal := range []string{
+		"(p \\land \\bigcirc \\Box p) \\to \\Box p",
+		"(\\Diamond p \\land \\Diamond q) \\to (\\Diamond (p \\land \\Diamond q) \\lor \\Diamond (q \\land \\Diamond p))",
+	} {
+		prover := Prover{System: SystemLTL}
+		_, model, err := prover.ProveOrRefute(&RawFormula{OID: 0, Formula: goal})
+		if err == nil || model != nil {
+			t.Errorf("got %v, %v want no countermodel and an error for %s", model, err, goal)
+		}
+	}
+
+	// Without LTL \bigcirc is not related to \Box
+	prover := Prover{System: SystemS4}
+	if _, err := prover.Prove(&RawFormula{Formula: "\\Box p \\to \\bigcirc p"}); err == nil {
+		t.Errorf("got nil want an error in S4")
+	}
+}
+
+func TestProverEquality(t *testing.T) {
+	cases := []struct {
+		goal   string
+		proved bool
+	}{
+		{"a = a", true},
+		{"\\forall x x = x", true},
+		{"a = b \\to b = a", true},
+		{"(a = b \\land b = c) \\to a = c", true},
+		{"a = b \\to f(a) = f(b)", true},
+		{"(a = b \\land p(a)) \\to p(b)", true},
+		{"(\\forall x f(x) = g(x)) \\to (p(f(a)) \\to p(g(a)))", true},
+		{"(a = b \\land \\Box p(a)) \\to \\Box p(b)", true},
+		{"\\Diamond (a = b) \\to (p(a) \\to p(b))", true},
+		{"\\Box (a = b) \\to \\Box (p(a) \\to p(b))", true},
+		{"(\\forall x x = a) \\to b = c", true},
+		{"a = b", false},
+		{"\\Box (a = b) \\to a = b", false},
+		{"\\Box (a = b) \\to (p(a) \\to p(b))", false},
+		{"p(a) \\to p(b)", false},
+		{"f(a) = f(b) \\to a = b", false},
+	}
+	for _, c := range cases {
+		proveAndCheck(t, &Prover{System: SystemK, MaxResolutions: 200}, &Prover{System: SystemK}, c.goal, c.proved)
+	}
+
+	// In a serial frame a world where a = b holds always exists
+	proveAndCheck(t, &Prover{System: SystemD}, &Prover{System: SystemD}, "\\Box (a = b) \\to (p(a) \\to p(b))", true)
+
+	prover := Prover{}
+	f, err := prover.parse(&RawFormula{Formula: "f(x) = g(a,b) \\to x=y"})
+	if err != nil {
+		t.Errorf("got error %s want nil", err)
+	} else if got, want := f.String(), "( f(x) = g(a,b) Implies x = y )"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if _, err := prover.parse(&RawFormula{Formula: "a = \\to b"}); err == nil {
+		t.Errorf("got nil want an error for a missing term")
+	}
+}
+
+func TestLatex(t *testing.T) {
+	cases := map[string]string{
+		"a \\to b \\to c":                          "a \\to b \\to c",
+		"(a \\to b) \\to c":                        "( a \\to b ) \\to c",
+		"\\lnot \\lnot a":                          "\\lnot \\lnot a",
+		"\\Box (a \\to \\Box_{b} c)":               "\\Box ( a \\to \\Box_{b} c )",
+		"\\forall x (p(x) \\to q(x))":              "\\forall x\\, p(x) \\to q(x)",
+		"(\\forall x p(x)) \\to q":                 "( \\forall x\\, p(x) ) \\to q",
+		"q \\to \\forall x p(x)":                   "q \\to \\forall x\\, p(x)",
+		"\\lnot (\\forall x, y p(x,y))":            "\\lnot \\forall x, y\\, p(x,y)",
+		"Or \\to Box":                              "Or \\to Box",
+		"\\bigcirc a \\to f(x) = g(a)":             "\\bigcirc a \\to f(x) = g(a)",
+		"\\lnot (a \\to \\lnot b) \\to c":          "\\lnot ( a \\to \\lnot b ) \\to c",
+		"\\lnot ((\\forall x p(x)) \\to \\lnot b)": "\\lnot ( ( \\forall x\\, p(x) ) \\to \\lnot b )",
+		"\\Box \\forall x p(x) \\to q":             "\\Box \\forall x\\, p(x) \\to q",
+		"(\\Box \\forall x p(x)) \\to q":           "\\Box ( \\forall x\\, p(x) ) \\to q",
+	}
+	for s, want := range cases {
+		prover := Prover{}
+		f, err := prover.parse(&RawFormula{Formula: s})
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, s)
+			continue
+		}
+		got := f.latex()
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+		g, err := prover.parse(&RawFormula{Formula: got})
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, got)
+		} else if g.String() != f.String() {
+			t.Errorf("got %s want %s parsing %s", g, f, got)
+		}
+	}
+
+	// World indexes are printed as subscripts and read back
+	prover := Prover{System: SystemK}
+	_, err := prover.Prove(&RawFormula{Formula: "\\Box_{a} (p \\to q) \\to \\Box_{a} p \\to \\Box_{a} q"})
+	if err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+	for _, s := range prover.sequents {
+		for _, f := range append(append([]*formula{}, s.Left...), s.Right...) {
+			g, err := prover.parse(&RawFormula{Formula: f.latex()})
+			if err != nil {
+				t.Errorf("got error %s want nil for %s", err, f.latex())
+			} else if g.latex() != f.latex() {
+				t.Errorf("got %s want %s", g.latex(), f.latex())
+			}
+		}
+	}
+}
+
+func TestProverNative(t *testing.T) {
+	cases := []struct {
+		goal   string
+		system string
+		proved bool
+	}{
+		{"p \\land q \\to q \\land p", SystemK, true},
+		{"p \\lor q \\to q \\lor p", SystemK, true},
+		{"( p \\iff q ) \\to ( q \\iff p )", SystemK, true},
+		{"p \\iff p", SystemK, true},
+		{"\\Box ( p \\lor q ) \\land \\Diamond \\lnot p \\to \\Diamond q", SystemK, true},
+		{"\\Diamond p \\to \\lnot \\Box \\lnot p", SystemK, true},
+		{"p \\to \\Diamond p", SystemT, true},
+		{"\\exists x p(x) \\to \\lnot \\forall x \\lnot p(x)", SystemK, true},
+		{"\\forall x p(x) \\to \\exists x p(x)", SystemK, true},
+		{"\\Diamond p \\land \\Diamond q \\to \\Diamond ( p \\land q )", SystemK, false},
+		{"\\forall y \\exists x r(x,y) \\to \\exists x \\forall y r(x,y)", SystemK, false},
+		{"\\lnot \\bigcirc p \\iff \\bigcirc \\lnot p", SystemLTL, true},
+	}
+	for _, c := range cases {
+		prover := Prover{System: c.system, Native: true, MaxSteps: 10000}
+		proveAndCheck(t, &prover, &Prover{System: c.system, Native: true}, c.goal, c.proved)
+	}
+
+	// The sequents keep the connectives of the formula
+	prover := Prover{Native: true}
+	proof, err := prover.BuildProof(context.Background(), &RawFormula{Formula: "p \\land q \\to p \\lor q"})
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	want := "( p \\land q \\to p \\lor q )_{0}"
+	if got := proof.Root.Sequent.Right[0].latex(); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestTokenizeSyntaxes(t *testing.T) {
+	cases := []struct {
+		in     string
+		syntax string
+		want   string
+	}{
+		{"\\Box ( p \\to q ) \\land \\lnot \\Diamond_{a} r", SyntaxTeX, "( ( Box ( p Implies q ) ) And ( Not ( Diamond_{a} r ) ) )"},
+		{"[] (p -> q) & ~<>_{a} r", SyntaxASCII, "( ( Box ( p Implies q ) ) And ( Not ( Diamond_{a} r ) ) )"},
+		{"□ (p → q) ∧ ¬◇_{a} r", SyntaxUnicode, "( ( Box ( p Implies q ) ) And ( Not ( Diamond_{a} r ) ) )"},
+		{"p | q <-> [p] | q", SyntaxASCII, "( ( p Or q ) Iff ( p Or q ) )"},
+		{"forall x, y. exists z. r(x,z) -> r(z,y)", SyntaxASCII, "( Forall ( x, y ) ( Exists ( z ) ( r(x,z) Implies r(z,y) ) ) )"},
+		{"∀x. ∃z. r(x,z) ∨ ○p", SyntaxUnicode, "( Forall ( x ) ( Exists ( z ) ( r(x,z) Or ( Next p ) ) ) )"},
+		{"[] p -> p", SyntaxAuto, "( ( Box p ) Implies p )"},
+		{"□ p → p", SyntaxAuto, "( ( Box p ) Implies p )"},
+		{"forall \\to p", SyntaxTeX, "( forall Implies p )"},
+	}
+	for _, c := range cases {
+		tokens, err := tokenize(c.in, c.syntax)
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, c.in)
+			continue
+		}
+		f, err := genFormulasTree(tokens)
+		if err != nil {
+			t.Errorf("got error %s want nil for %s", err, c.in)
+			continue
+		}
+		if fmt.Sprint(f) != c.want {
+			t.Errorf("got %s want %s", f, c.want)
+		}
+	}
+
+	prover := Prover{Syntax: SyntaxASCII}
+	if _, err := prover.Prove(&RawFormula{Formula: "[](p -> q) -> ([]p -> []q)"}); err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+	prover = Prover{Syntax: "latex"}
+	if _, err := prover.Prove(&RawFormula{Formula: "p \\to p"}); err == nil {
+		t.Errorf("got nil want an error for an unknown syntax")
+	}
+	_, err := Parse("p", "latex")
+	if perr, ok := err.(*ParseError); !ok || perr.Offset != 0 || perr.Reason != "unknown syntax latex" {
+		t.Errorf("got %v want a *ParseError at offset 0 for an unknown syntax", err)
+	}
+}
+
+func TestReadTPTP(t *testing.T) {
+	in := `%------------------------------------------------------------------------------
+% File     : SYM001+1 : QMLTP v1.1
+%------------------------------------------------------------------------------
+tff(s4, logic, $modal == [$constants == $rigid, $quantification == $constant, $modalities == $modal_system_S4]).
+qmf(ax, axiom, ! [X] : ( #box : f(X) )).
+/* the conjecture */
+qmf(con, conjecture,
+    ( #box : ( ! [X,Y] : ( ( f(X) & X = Y ) => #dia : f(Y) ) ) ) | ~ $true).
+`
+	p, err := ReadTPTP(strings.NewReader(in), ".")
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	if p.System != SystemS4 || p.Quantification != "constant" || p.Constants != "rigid" {
+		t.Errorf("got %s %s %s want S4 constant rigid", p.System, p.Quantification, p.Constants)
+	}
+	want := "( ( \\Box ( \\forall X, Y ( ( f(X) \\land ( X = Y ) ) \\to ( \\Diamond f(Y) ) ) ) ) \\lor ( \\lnot ( True \\to True ) ) )"
+	if p.Conjecture.Formula != want {
+		t.Errorf("got %s want %s", p.Conjecture.Formula, want)
+	}
+	if len(p.Premises) != 1 || p.Premises[0].Formula != "( \\forall X ( \\Box f(X) ) )" {
+		t.Errorf("got %v want one premise", p.Premises)
+	}
+
+	prover := Prover{System: p.System, Syntax: SyntaxTeX, Premises: p.Premises}
+	if _, err := prover.Prove(p.Conjecture); err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+
+	for _, in := range []string{
+		"fof(a, axiom, p).",
+		"fof(c, conjecture, p & ).",
+		"fof(c, conjecture, 'a b').",
+		"cnf(c, conjecture, p).",
+		"fof(c, conjecture, p => q => r).",
+	} {
+		if _, err := ReadTPTP(strings.NewReader(in), "."); err == nil {
+			t.Errorf("got nil want an error for %s", in)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse("\\forall x ( p(x) \\land \\Diamond_{a} q ) \\lor \\bigcirc r", SyntaxTeX)
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	if f.Operator() != OpForall || len(f.Vars()) != 1 || f.Vars()[0] != "x" {
+		t.Errorf("got %s %v want Forall [x]", f.Operator(), f.Vars())
+	}
+	ops := []string{}
+	Inspect(f, func(g *Formula) bool {
+		if g.Operator() == "" {
+			ops = append(ops, fmt.Sprintf("%s%v", g.Name(), g.Args()))
+		} else {
+			ops = append(ops, g.Operator()+g.Agent())
+		}
+		return true
+	})
+	want := "Forall Or And p[x] Diamonda q[] Boxnext r[]"
+	if got := strings.Join(ops, " "); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	r := f.Reduce()
+	if r.String() == f.String() {
+		t.Errorf("got %s want a reduced formula", r)
+	}
+	Inspect(r, func(g *Formula) bool {
+		switch g.Operator() {
+		case OpAnd, OpOr, OpIff, OpDiamond, OpExists:
+			t.Errorf("got %s in %s", g.Operator(), r)
+		}
+		return true
+	})
+	if f.Operands()[0].Operator() != OpOr {
+		t.Errorf("got %s want %s unchanged by Reduce", f, OpOr)
+	}
+
+	cases := []struct {
+		in     string
+		offset int
+	}{
+		{"p \\to ( q", 6},
+		{"p \\to q )", 8},
+		{"( p ]", 4},
+		{"p \\foo q", 2},
+		{"p q", 2},
+		{"\\lnot", 0},
+		{"", 0},
+		{"□ p → p(a,", 10},
+		{"\\", 0},
+		{"p \\B", 2},
+		{"p \\Boxq", 2},
+		{"_", 1},
+		{"p_{}", 1},
+		{"\\forall p(x)\\, q", 8},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.in, SyntaxAuto)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("got %v want a ParseError for %s", err, c.in)
+			continue
+		}
+		if perr.Offset != c.offset {
+			t.Errorf("got offset %d want %d for %s: %s", perr.Offset, c.offset, c.in, perr)
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	cases := []struct {
+		in       string
+		line     int
+		column   int
+		expected string
+		found    string
+		caret    string
+	}{
+		{"p \\to\n( q \\qux r )", 2, 5, "operator", "\\qux", "( q \\qux r )\n    ^^^^"},
+		{"□ p → p(a,", 1, 11, ")", "end of input", "□ p → p(a,\n          ^"},
+		{"p \\to ( q ]", 1, 11, ")", "]", "p \\to ( q ]\n          ^"},
+		{"p_{", 1, 4, "}", "end of input", "p_{\n   ^"},
+		{"é \\to p", 1, 1, "formula", "é", "é \\to p\n^"},
+		{"□ p →\n  q ∧ é", 2, 7, "formula", "é", "  q ∧ é\n      ^"},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.in, SyntaxAuto)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("got %v want a ParseError for %s", err, c.in)
+			continue
+		}
+		got := fmt.Sprintf("%d:%d %s %s", perr.Line, perr.Column, perr.Expected, perr.Found)
+		want := fmt.Sprintf("%d:%d %s %s", c.line, c.column, c.expected, c.found)
+		if got != want {
+			t.Errorf("got %s want %s for %s: %s", got, want, c.in, perr)
+		}
+		if perr.Caret() != c.caret {
+			t.Errorf("got %q want %q for %s", perr.Caret(), c.caret, c.in)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{
+		"\\Box ( a \\to b ) \\to ( \\Box a \\to \\Box b )",
+		"\\forall x ( p(x) \\land \\Diamond_{a} q ) \\lor \\bigcirc r",
+		"K_{a} p \\to p_{1}",
+		"a = b \\land p(f(a), b)",
+		"[](p -> q) -> <>p & ~q | (p <-> q)",
+		"∀x ∃y r(x,y) → □ p",
+		"\\", "\\B", "_", "p_", "p_{", "K_", "p(a,", "(", ")", "",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		g, err := Parse(s, SyntaxAuto)
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok {
+				t.Errorf("got %T want a ParseError for %q", err, s)
+			}
+			return
+		}
+		g.Reduce()
+		g.Latex()
+		p := Prover{System: SystemK, MaxSteps: 200, Timeout: time.Second}
+		p.Prove(&RawFormula{Formula: s})
+	})
+}
+
+func TestNormalForms(t *testing.T) {
+	f, err := Parse("\\lnot ( p \\to \\Box ( q \\land r ) ) \\lor \\lnot \\exists x s(x)", SyntaxTeX)
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	cases := []struct {
+		got  string
+		want string
+	}{
+		{f.NNF().Latex(), "( p \\land \\Diamond ( \\lnot q \\lor \\lnot r ) ) \\lor \\forall x\\, \\lnot s(x)"},
+		{f.CNF().Latex(), "p \\lor ( \\forall x\\, \\lnot s(x) ) \\land \\Diamond ( \\lnot q \\lor \\lnot r ) \\lor \\forall x\\, \\lnot s(x)"},
+	}
+	g, err := Parse("\\Box ( p \\lor ( q \\land r ) ) \\lor \\lnot \\bigcirc s", SyntaxTeX)
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	cases = append(cases, struct {
+		got  string
+		want string
+	}{g.ModalCNF().Latex(), "\\Box ( p \\lor q \\land p \\lor r ) \\lor \\bigcirc \\lnot s"})
+	renamed, defs := g.RenamedModalCNF()
+	cases = append(cases, struct {
+		got  string
+		want string
+	}{renamed.Latex(), "\\Box def1 \\lor \\bigcirc \\lnot s"})
+	if len(defs) != 1 {
+		t.Fatalf("got %d definitions want 1", len(defs))
+	}
+	cases = append(cases, struct {
+		got  string
+		want string
+	}{defs[0].Latex(), "p \\lor q \\land p \\lor r \\to def1"})
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("got %s want %s", c.got, c.want)
+		}
+	}
+
+	// Copies, tautologies and subsumed clauses are dropped
+	k, err := Parse("( a \\lor b ) \\land ( b \\lor a ) \\land ( a \\lor b \\lor c ) \\land ( c \\lor \\lnot c )", SyntaxTeX)
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	if got, want := k.CNF().Latex(), "a \\lor b"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	// Renaming keeps the free variables as arguments of the fresh predicates
+	h, err := Parse("\\forall x \\Box ( p(x) \\land q )", SyntaxTeX)
+	if err != nil {
+		t.Fatalf("got error %s want nil", err)
+	}
+	renamed, defs = h.RenamedModalCNF()
+	got := renamed.Latex()
+	for _, d := range defs {
+		got = got + "; " + d.Latex()
+	}
+	want := "\\forall x\\, \\Box def1(x); \\forall x\\, p(x) \\land q \\to def1(x)"
+	if got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	goals := []struct {
+		goal   string
+		system string
+		proved bool
+	}{
+		{"\\Box ( a \\to b ) \\to ( \\Box a \\to \\Box b )", SystemK, true},
+		{"\\Box p \\land \\Box q \\to \\Box ( p \\land q )", SystemK, true},
+		{"\\Diamond ( p \\lor q ) \\to \\Diamond p \\lor \\Diamond q", SystemK, true},
+		{"\\Box ( p \\land \\Box ( q \\lor r ) ) \\to \\Box \\Box ( r \\lor q )", SystemK, true},
+		{"( p \\land q ) \\lor ( r \\land s ) \\to ( p \\lor r )", SystemK, true},
+		{"( \\forall x \\Box p(x) ) \\to \\Box \\forall x p(x)", SystemK, true},
+		{"\\Box p \\to p", SystemK, false},
+		{"\\Diamond p \\land \\Diamond q \\to \\Diamond ( p \\land q )", SystemK, false},
+		{"\\Box ( a \\land b ) \\iff ( \\Box a \\land \\Box b )", SystemK, true},
+		{"( a \\land ( b \\lor c ) ) \\iff ( ( a \\land b ) \\lor ( a \\land c ) )", SystemK, true},
+		{"\\Box ( p \\iff q ) \\to ( \\Box p \\iff \\Box q )", SystemK, true},
+	}
+	for _, form := range []string{NormalFormNNF, NormalFormCNF, NormalFormModalCNF, NormalFormRenamed} {
+		for _, native := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s native %t", form, native), func(t *testing.T) {
+				for _, c := range goals {
+					prover := Prover{System: c.system, NormalForm: form, Native: native, MaxSteps: 30000}
+					proveAndCheck(t, &prover, &Prover{System: c.system, NormalForm: form, Native: native}, c.goal, c.proved)
+				}
+			})
+		}
+	}
+
+	// Premises and axioms are renamed the other way round
+	prover := Prover{System: SystemK, NormalForm: NormalFormRenamed, MaxSteps: 10000}
+	prover.Premises = []*RawFormula{{OID: 1, Formula: "\\Box ( p \\land q )"}}
+	prover.Axioms = []*RawFormula{{OID: 2, Formula: "q \\to \\Diamond ( r \\land s )"}}
+	if _, err := prover.Prove(&RawFormula{Formula: "\\Box \\Diamond s"}); err != nil {
+		t.Errorf("got error %s want nil", err)
+	}
+
+	if _, err := (&Prover{NormalForm: "dnf"}).Prove(&RawFormula{Formula: "p"}); err == nil {
+		t.Errorf("got nil want an error for an unknown normal form")
+	}
+}
+
+func TestFormulaTable(t *testing.T) {
+	read := func(s string) *formula {
+		f, err := Parse(s, SyntaxTeX)
+		if err != nil {
+			t.Fatalf("got error %s want nil for %s", err, s)
+		}
+		return reduceFormulas(f.f)
+	}
+	table := newFormulaTable()
+	f := table.intern(read("\\Box ( p(a) \\to q ) \\to \\Box ( p(a) \\to q )"))
+	g := table.intern(read("\\Box ( p(a) \\to q ) \\to \\Box ( p(a) \\to q )"))
+	if f != g {
+		t.Errorf("got %p and %p want the same node", f, g)
+	}
+	if f.Operands[0] != f.Operands[1] {
+		t.Errorf("got different nodes want shared operands in %s", f)
+	}
+	if f.size() != 9 {
+		t.Errorf("got size %d want 9", f.size())
+	}
+	if table.intern(f) != f {
+		t.Errorf("got a new node want %s", f)
+	}
+
+	h := read("\\Box ( p(a) \\to q ) \\to \\Box ( p(a) \\to q )")
+	h.Index = worldindex{[]*worldsymbol{{Value: "0", Ground: true}}}
+	if i := table.intern(h); i == f || sameFormula(i, f) {
+		t.Errorf("got %s equal to %s want different formulas", i, f)
+	}
+	c := copyTopFormulaLevel(f)
+	if table.owns(c) || !sameFormula(c, f) {
+		t.Errorf("got a copy owned by the table or different from %s", f)
+	}
+	x := table.intern(read("\\forall x p(x)"))
+	y := table.intern(read("p(x)"))
+	if x.Operands[1] == y {
+		t.Errorf("got the bound variable x equal to the constant x")
+	}
+
+	s1 := &Sequent{Left: []*formula{read("p")}, Right: []*formula{read("q \\to p")}}
+	s2 := &Sequent{Left: []*formula{read("p")}, Right: []*formula{read("q \\to p")}}
+	table.internSequent(s1)
+	table.internSequent(s2)
+	if sequentKey(s1) != sequentKey(s2) {
+		t.Errorf("got %s and %s want the same key", sequentKey(s1), sequentKey(s2))
+	}
+}
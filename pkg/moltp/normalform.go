@@ -0,0 +1,380 @@
+package moltp
+
+import "fmt"
+
+// duals holds the operator a negation turns each operator into
+var duals = map[string]string{
+	sAND:     sOR,
+	sOR:      sAND,
+	sBOX:     sDIAMOND,
+	sDIAMOND: sBOX,
+	sFORALL:  sEXISTS,
+	sEXISTS:  sFORALL,
+}
+
+// nnf returns a copy of f, or of its negation when neg is set, in negation normal form:
+// \to and \iff are rewritten with \land and \lor and \lnot is applied to atoms only.
+// Next is its own dual, \lnot \bigcirc A is \bigcirc \lnot A
+func nnf(f *formula, neg bool) *formula {
+	if len(f.Operands) == 0 {
+		g := copyTopFormulaLevel(f)
+		if neg {
+			return &formula{Terminal: sNOT, Operands: []*formula{g}}
+		}
+		return g
+	}
+	var g *formula
+	switch f.Terminal {
+	case sNOT:
+		g = nnf(f.Operands[0], !neg)
+	case sAND, sOR:
+		g = &formula{Terminal: f.Terminal, Operands: []*formula{nnf(f.Operands[0], neg), nnf(f.Operands[1], neg)}}
+	case sIMPLY:
+		// A \to B = \lnot A \lor B
+		g = &formula{Terminal: sOR, Operands: []*formula{nnf(f.Operands[0], !neg), nnf(f.Operands[1], neg)}}
+	case sIFF:
+		// A \iff B = ( \lnot A \lor B ) \land ( A \lor \lnot B ), its negation swaps the negated operands
+		A, B := f.Operands[0], f.Operands[1]
+		g = &formula{Terminal: sAND, Operands: []*formula{
+			{Terminal: sOR, Operands: []*formula{nnf(A, !neg), nnf(B, false)}},
+			{Terminal: sOR, Operands: []*formula{nnf(A, neg), nnf(B, true)}},
+		}}
+		return withIndex(g, f.Index)
+	case sBOX, sDIAMOND:
+		g = &formula{Terminal: f.Terminal, Agent: f.Agent, Operands: []*formula{nnf(f.Operands[0], neg)}}
+		if f.Agent == agentNext {
+			return withIndex(g, f.Index)
+		}
+	case sFORALL, sEXISTS:
+		last := len(f.Operands) - 1
+		g = &formula{Terminal: f.Terminal, Vars: append([]string{}, f.Vars...)}
+		for _, v := range f.Operands[:last] {
+			g.Operands = append(g.Operands, copyTopFormulaLevel(v))
+		}
+		g.Operands = append(g.Operands, nnf(f.Operands[last], neg))
+	default:
+		return deepCopy(f)
+	}
+	if neg && f.Terminal != sNOT {
+		if d, ok := duals[g.Terminal]; ok {
+			g.Terminal = d
+		}
+	}
+	return withIndex(g, f.Index)
+}
+
+// withIndex gives the world index i to f unless it has its own
+func withIndex(f *formula, i worldindex) *formula {
+	if len(f.Index.Symbols) == 0 {
+		f.Index = i
+	}
+	return f
+}
+
+// normalForm returns f, which is in negation normal form, as a conjunction of clauses.
+// positive tells whether f is proved, as the goal, or assumed, as a premise or an axiom
+func (n *normalizer) normalForm(f *formula, positive bool) *formula {
+	g := copyTopFormulaLevel(f)
+	g.Index = worldindex{}
+	return withIndex(joinClauses(n.clauses(g, positive)), f.Index)
+}
+
+// clauses returns the clauses of f, which is in negation normal form, each one is a list of literals.
+// Literals are atoms, negated atoms and formulas built by a modal operator or a quantifier,
+// subformulas with a world index are literals too
+func (n *normalizer) clauses(f *formula, positive bool) [][]*formula {
+	if len(f.Index.Symbols) > 0 {
+		return [][]*formula{{n.normalForm(f, positive)}}
+	}
+	switch {
+	case f.is(sAND):
+		return simplifyClauses(append(n.clauses(f.Operands[0], positive), n.clauses(f.Operands[1], positive)...))
+	case f.is(sOR):
+		left := n.clauses(f.Operands[0], positive)
+		right := n.clauses(f.Operands[1], positive)
+		if n.Rename && len(left) > 1 && len(right) > 1 {
+			// distributing the disjunction would multiply the clauses
+			right = [][]*formula{{n.define(joinClauses(right), positive)}}
+		}
+		out := [][]*formula{}
+		for _, c := range left {
+			for _, d := range right {
+				out = append(out, mergeClauses(c, d))
+			}
+		}
+		return simplifyClauses(out)
+	case n.Modal && (f.is(sBOX) || f.is(sDIAMOND)):
+		g := copyTopFormulaLevel(f)
+		o := n.normalForm(f.Operands[0], positive)
+		if n.Rename && !isLiteral(o) {
+			o = n.define(o, positive)
+		}
+		g.Operands[0] = o
+		return [][]*formula{{g}}
+	case n.Modal && (f.is(sFORALL) || f.is(sEXISTS)):
+		g := copyTopFormulaLevel(f)
+		last := len(g.Operands) - 1
+		g.Operands[last] = n.normalForm(g.Operands[last], positive)
+		return [][]*formula{{g}}
+	}
+	return [][]*formula{{f}}
+}
+
+// isLiteral checks that f is an atom, a negated atom or a formula built by a modal operator or a quantifier
+func isLiteral(f *formula) bool {
+	if f.is(sNOT) {
+		return len(f.Operands[0].Operands) == 0
+	}
+	return !f.is(sAND) && !f.is(sOR)
+}
+
+// mergeClauses returns the disjunction of two clauses, each literal is copied once
+func mergeClauses(c, d []*formula) []*formula {
+	out := []*formula{}
+	seen := make(map[string]bool)
+	for _, l := range append(append([]*formula{}, c...), d...) {
+		if k := l.String(); !seen[k] {
+			seen[k] = true
+			out = append(out, deepCopy(l))
+		}
+	}
+	return out
+}
+
+// simplifyClauses removes the tautologies, the copies and the subsumed clauses of cs, the conjunction
+// of the clauses left holds in the same worlds. A tautology is kept when every clause is one
+func simplifyClauses(cs [][]*formula) [][]*formula {
+	out := [][]*formula{}
+	for _, c := range cs {
+		if !isTautology(c) {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return cs[:1]
+	}
+	kept := [][]*formula{}
+	for i, c := range out {
+		subsumed := false
+		for j, d := range out {
+			// of two copies only the first one is kept
+			if i != j && containsClause(c, d) && (j < i || !containsClause(d, c)) {
+				subsumed = true
+				break
+			}
+		}
+		if !subsumed {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// isTautology checks that the clause c holds a literal and its negation
+func isTautology(c []*formula) bool {
+	seen := make(map[string]bool)
+	for _, l := range c {
+		seen[l.String()] = true
+	}
+	for _, l := range c {
+		if seen[nnf(l, true).String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// containsClause checks that each literal of d is a literal of c, so c is implied by d
+func containsClause(c, d []*formula) bool {
+	seen := make(map[string]bool)
+	for _, l := range c {
+		seen[l.String()] = true
+	}
+	for _, l := range d {
+		if !seen[l.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+// joinClauses builds the conjunction of the disjunctions of the literals of each clause
+func joinClauses(cs [][]*formula) *formula {
+	out := joinFormulas(sOR, cs[len(cs)-1])
+	for i := len(cs) - 2; i >= 0; i-- {
+		out = &formula{Terminal: sAND, Operands: []*formula{joinFormulas(sOR, cs[i]), out}}
+	}
+	return out
+}
+
+// joinFormulas builds the right associative formula of the binary operator op applied to fs
+func joinFormulas(op string, fs []*formula) *formula {
+	out := fs[len(fs)-1]
+	for i := len(fs) - 2; i >= 0; i-- {
+		out = &formula{Terminal: op, Operands: []*formula{fs[i], out}}
+	}
+	return out
+}
+
+// define returns a fresh predicate applied to the free variables of f and records its definition.
+// A fresh predicate q can replace f in the goal when f \to q holds in every world and it can replace
+// f in a premise or in an axiom when q \to f holds in every world
+func (n *normalizer) define(f *formula, positive bool) *formula {
+	n.Next = n.Next + 1
+	name := fmt.Sprintf("def%d", n.Next)
+	for n.Used[name] {
+		n.Next = n.Next + 1
+		name = fmt.Sprintf("def%d", n.Next)
+	}
+	n.Used[name] = true
+	vars := freeVariables(f, make(map[string]bool), nil)
+	predicate := func() *formula {
+		q := &formula{Terminal: name}
+		for _, v := range vars {
+			q.Args = append(q.Args, &term{Value: v, IsVar: true})
+		}
+		return q
+	}
+	d := &formula{Terminal: sIMPLY, Operands: []*formula{f, predicate()}}
+	if !positive {
+		d.Operands = []*formula{predicate(), f}
+	}
+	if len(vars) > 0 {
+		q := &formula{Terminal: sFORALL, Vars: vars}
+		for _, v := range vars {
+			q.Operands = append(q.Operands, &formula{Terminal: v})
+		}
+		q.Operands = append(q.Operands, d)
+		d = q
+	}
+	n.Definitions = append(n.Definitions, d)
+	return predicate()
+}
+
+// freeVariables appends to vars the variables of f which are not bound in f, bound holds the variables bound so far
+func freeVariables(f *formula, bound map[string]bool, vars []string) []string {
+	if f.is(sFORALL) || f.is(sEXISTS) {
+		scope := make(map[string]bool)
+		for k, v := range bound {
+			scope[k] = v
+		}
+		for _, v := range f.Vars {
+			scope[v] = true
+		}
+		return freeVariables(f.Operands[len(f.Operands)-1], scope, vars)
+	}
+	for _, o := range f.Operands {
+		vars = freeVariables(o, bound, vars)
+	}
+	for _, t := range f.Args {
+		vars = freeTermVariables(t, bound, vars)
+	}
+	return vars
+}
+
+func freeTermVariables(t *term, bound map[string]bool, vars []string) []string {
+	if t.IsVar && !bound[t.Value] {
+		for _, v := range vars {
+			if v == t.Value {
+				return vars
+			}
+		}
+		return append(vars, t.Value)
+	}
+	for _, a := range t.Args {
+		vars = freeTermVariables(a, bound, vars)
+	}
+	return vars
+}
+
+// collectPredicates marks the names of the atoms of f as used
+func collectPredicates(f *formula, used map[string]bool) {
+	if len(f.Operands) == 0 {
+		used[f.Terminal] = true
+	}
+	for _, o := range f.Operands {
+		collectPredicates(o, used)
+	}
+}
+
+// newNormalizer returns the normalizer of the normal form, the fresh predicates do not occur in fs
+func newNormalizer(form string, fs ...*formula) *normalizer {
+	n := &normalizer{
+		Modal:  form == NormalFormModalCNF || form == NormalFormRenamed,
+		Rename: form == NormalFormRenamed,
+		Used:   make(map[string]bool),
+	}
+	for _, f := range fs {
+		collectPredicates(f, n.Used)
+	}
+	return n
+}
+
+// normalize turns the goal, the premises and the axioms of the problem into the normal form of the prover,
+// the goal becomes the negation of the normal form of its negation, the definitions of the fresh predicates are added to the axioms
+func (p *Prover) normalize(pr *problem) {
+	if p.NormalForm == NormalFormNone {
+		return
+	}
+	all := append(append([]*formula{pr.Goal}, pr.Premises...), pr.Axioms...)
+	n := newNormalizer(p.NormalForm, all...)
+	convert := func(f *formula, positive bool) *formula {
+		if p.NormalForm == NormalFormNNF {
+			return nnf(f, false)
+		}
+		if positive {
+			// The goal is refuted, so its negation is the one in normal form: its clauses
+			// are reduced to one sequent each instead of the product of the clauses of the goal
+			g := n.normalForm(nnf(f, true), false)
+			return withIndex(&formula{Terminal: sNOT, Operands: []*formula{g}}, f.Index)
+		}
+		return n.normalForm(nnf(f, false), positive)
+	}
+	if n.Rename {
+		pr.Depth = pr.Goal.modalDepth()
+		for _, h := range pr.Premises {
+			if d := h.modalDepth(); d > pr.Depth {
+				pr.Depth = d
+			}
+		}
+	}
+	pr.Goal = convert(pr.Goal, true)
+	for i, h := range pr.Premises {
+		pr.Premises[i] = convert(h, false)
+	}
+	for i, a := range pr.Axioms {
+		pr.Axioms[i] = convert(a, false)
+	}
+	pr.Axioms = append(pr.Axioms, n.Definitions...)
+}
+
+// NNF returns f in negation normal form, written with \land, \lor, \Box, \Diamond, \forall and \exists
+// and with \lnot applied to atoms only
+func (f *Formula) NNF() *Formula {
+	return &Formula{f: nnf(f.f, false)}
+}
+
+// CNF returns f as a conjunction of disjunctions of literals, formulas built by a modal operator
+// or a quantifier are literals and their operands are left in negation normal form
+func (f *Formula) CNF() *Formula {
+	return &Formula{f: newNormalizer(NormalFormCNF).normalForm(nnf(f.f, false), true)}
+}
+
+// ModalCNF works like CNF, but the operands of the modal operators and of the quantifiers are in modal CNF too
+func (f *Formula) ModalCNF() *Formula {
+	return &Formula{f: newNormalizer(NormalFormModalCNF).normalForm(nnf(f.f, false), true)}
+}
+
+// RenamedModalCNF works like ModalCNF, but the operands of the modal operators which are not literals
+// and the conjunctions which would be distributed over a disjunction are replaced by fresh predicates.
+// The definitions of the fresh predicates are returned too: f is valid if and only if the returned formula
+// is valid when the definitions hold in every world
+func (f *Formula) RenamedModalCNF() (*Formula, []*Formula) {
+	n := newNormalizer(NormalFormRenamed, f.f)
+	g := n.normalForm(nnf(f.f, false), true)
+	defs := make([]*Formula, len(n.Definitions))
+	for i, d := range n.Definitions {
+		defs[i] = &Formula{f: d}
+	}
+	return &Formula{f: g}, defs
+}
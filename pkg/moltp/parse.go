@@ -0,0 +1,245 @@
+package moltp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Operators of the formulas returned by Parse
+const (
+	OpNot     = sNOT
+	OpAnd     = sAND
+	OpOr      = sOR
+	OpImplies = sIMPLY
+	OpIff     = sIFF
+	OpBox     = sBOX
+	OpDiamond = sDIAMOND
+	OpForall  = sFORALL
+	OpExists  = sEXISTS
+)
+
+// AgentNext is the agent of the Box read from \bigcirc
+const AgentNext = agentNext
+
+// endOfInput is the byte offset of a problem found at the end of the input
+const endOfInput = -1
+
+// closingBrackets holds the closing bracket of each kind of bracket
+var closingBrackets = map[string]string{"Round": ")", "Square": "]", "Curly": "}"}
+
+func (e *ParseError) Error() string {
+	out := fmt.Sprintf("%s at line %d column %d", e.Reason, e.Line, e.Column)
+	if e.Expected != "" {
+		out = fmt.Sprintf("%s, expected %s found %s", out, e.Expected, e.Found)
+	}
+	return out
+}
+
+// Caret returns the line holding the problem with a caret under it
+func (e *ParseError) Caret() string {
+	pad := []rune{}
+	for i, r := range []rune(e.Source) {
+		if i >= e.Column-1 {
+			break
+		}
+		if r != '\t' {
+			r = ' '
+		}
+		pad = append(pad, r)
+	}
+	n := e.End - e.Offset
+	if rest := utf8.RuneCountInString(e.Source) - len(pad); n > rest {
+		n = rest
+	}
+	if n < 1 {
+		n = 1
+	}
+	return fmt.Sprintf("%s\n%s%s", e.Source, string(pad), strings.Repeat("^", n))
+}
+
+// syntaxError returns a *ParseError for the bytes from, to of the input, the text found is read by located
+func syntaxError(from, to int, expected, reason string) *ParseError {
+	return &ParseError{from: from, to: to, Expected: expected, Reason: reason}
+}
+
+// shifted moves a *ParseError found reading a suffix of the input, starting at byte n, to the whole input
+func shifted(err error, n int) error {
+	perr, ok := err.(*ParseError)
+	if !ok {
+		return &ParseError{from: n, to: n, Reason: err.Error()}
+	}
+	if perr.from == endOfInput {
+		return perr
+	}
+	return &ParseError{from: perr.from + n, to: perr.to + n, Expected: perr.Expected, Reason: perr.Reason}
+}
+
+// located fills the position of a *ParseError found in s, when the end of the problem is not known
+// the name or the symbol at its beginning is taken
+func located(s string, err error) error {
+	perr, ok := err.(*ParseError)
+	if !ok {
+		return err
+	}
+	from, to := perr.from, perr.to
+	if from < 0 || from > len(s) {
+		from = len(s)
+	}
+	if to <= from && from < len(s) {
+		if v := matchIdentifier(s[from:]); v != "" {
+			to = from + len(v)
+		} else {
+			_, n := utf8.DecodeRuneInString(s[from:])
+			to = from + n
+		}
+	}
+	if to < from {
+		to = from
+	}
+	if to > len(s) {
+		to = len(s)
+	}
+	found := s[from:to]
+	if from == len(s) {
+		found = "end of input"
+	}
+	start := strings.LastIndexByte(s[:from], '\n') + 1
+	end := strings.IndexByte(s[from:], '\n')
+	if end < 0 {
+		end = len(s)
+	} else {
+		end = from + end
+	}
+	return &ParseError{
+		Offset:   utf8.RuneCountInString(s[:from]),
+		End:      utf8.RuneCountInString(s[:to]),
+		Line:     strings.Count(s[:from], "\n") + 1,
+		Column:   utf8.RuneCountInString(s[start:from]) + 1,
+		Expected: perr.Expected,
+		Found:    found,
+		Reason:   perr.Reason,
+		Source:   s[start:end],
+	}
+}
+
+// Parse reads a formula written in the given syntax, one of the Syntax constants.
+// The formula keeps the connectives it was written with, see Reduce.
+// Errors are *ParseError
+func Parse(s, syntax string) (*Formula, error) {
+	switch syntax {
+	case SyntaxAuto, SyntaxTeX, SyntaxASCII, SyntaxUnicode:
+	default:
+		return nil, located(s, syntaxError(0, 0, "", fmt.Sprintf("unknown syntax %s", syntax)))
+	}
+	tokens, err := tokenize(s, syntax)
+	if err != nil {
+		return nil, located(s, err)
+	}
+	f, err := genFormulasTree(tokens)
+	if err != nil {
+		return nil, located(s, err)
+	}
+	return &Formula{f: f}, nil
+}
+
+// Reduce returns the formula written with \lnot, \to, \Box and \forall only, as it is proved by the prover
+func (f *Formula) Reduce() *Formula {
+	return &Formula{f: reduceFormulas(deepCopy(f.f))}
+}
+
+func deepCopy(f *formula) *formula {
+	g := copyTopFormulaLevel(f)
+	for i, o := range g.Operands {
+		g.Operands[i] = deepCopy(o)
+	}
+	return g
+}
+
+// Operator returns the operator of f, one of the Op constants, or the empty string for an atom
+func (f *Formula) Operator() string {
+	if len(f.f.Operands) == 0 {
+		return ""
+	}
+	return f.f.Terminal
+}
+
+// Name returns the predicate of an atom, equalities are atoms named =
+func (f *Formula) Name() string {
+	if len(f.f.Operands) > 0 {
+		return ""
+	}
+	return f.f.Terminal
+}
+
+// Args returns the arguments of an atom
+func (f *Formula) Args() []string {
+	out := []string{}
+	for _, a := range f.f.Args {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+// Vars returns the variables of a quantifier
+func (f *Formula) Vars() []string {
+	return append([]string{}, f.f.Vars...)
+}
+
+// Agent returns the agent of an indexed modality, AgentNext for \bigcirc
+func (f *Formula) Agent() string {
+	return f.f.Agent
+}
+
+// Index returns the world index of f, the empty string when it has none
+func (f *Formula) Index() string {
+	return f.f.Index.String()
+}
+
+// Operands returns the operands of f, the body of a quantifier is its only operand
+func (f *Formula) Operands() []*Formula {
+	ops := f.f.Operands
+	if f.f.is(sFORALL) || f.f.is(sEXISTS) {
+		ops = ops[len(ops)-1:]
+	}
+	out := make([]*Formula, len(ops))
+	for i, o := range ops {
+		out[i] = &Formula{f: o}
+	}
+	return out
+}
+
+func (f *Formula) String() string {
+	return f.f.String()
+}
+
+// Latex returns f in TeX notation using as few brackets as possible
+func (f *Formula) Latex() string {
+	return f.f.latex()
+}
+
+// Walk visits f in depth first order: it calls v.Visit(f) and then walks the operands of f
+// with the returned Visitor, unless it is nil
+func Walk(v Visitor, f *Formula) {
+	if v = v.Visit(f); v == nil {
+		return
+	}
+	for _, o := range f.Operands() {
+		Walk(v, o)
+	}
+}
+
+// inspector is the Visitor used by Inspect
+type inspector func(*Formula) bool
+
+func (fn inspector) Visit(f *Formula) Visitor {
+	if fn(f) {
+		return fn
+	}
+	return nil
+}
+
+// Inspect visits f in depth first order calling fn on every formula, the operands of a formula are skipped when fn returns false
+func Inspect(f *Formula, fn func(*Formula) bool) {
+	Walk(inspector(fn), f)
+}
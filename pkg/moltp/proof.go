@@ -0,0 +1,176 @@
+package moltp
+
+import (
+	"context"
+	"fmt"
+)
+
+// BuildProof works like ProveContext, but it returns the derivation as a Proof
+// When no solution is found the partial derivation is returned together with the error
+func (p *Prover) BuildProof(ctx context.Context, rf *RawFormula) (*Proof, error) {
+	solution, err := p.ProveContext(ctx, rf)
+	if solution == nil {
+		return nil, err
+	}
+	proof := newProof(solution, p.sequents)
+	proof.Settings = p.settings()
+	return proof, err
+}
+
+// settings returns the settings of p a proof is checked with
+func (p *Prover) settings() *ProofSettings {
+	return &ProofSettings{
+		System:      p.System,
+		Frame:       p.Frame,
+		Agents:      p.Agents,
+		AgentFrames: p.AgentFrames,
+		Native:      p.Native,
+		Syntax:      p.Syntax,
+		NormalForm:  p.NormalForm,
+		Premises:    p.Premises,
+		Axioms:      p.Axioms,
+		AxiomDepth:  p.AxiomDepth,
+	}
+}
+
+// withSettings returns a Prover with the settings s, it logs like p and has no limits
+func (p *Prover) withSettings(s *ProofSettings) *Prover {
+	return &Prover{
+		Debug:       p.Debug,
+		System:      s.System,
+		Frame:       s.Frame,
+		Agents:      s.Agents,
+		AgentFrames: s.AgentFrames,
+		Native:      s.Native,
+		Syntax:      s.Syntax,
+		NormalForm:  s.NormalForm,
+		Premises:    s.Premises,
+		Axioms:      s.Axioms,
+		AxiomDepth:  s.AxiomDepth,
+	}
+}
+
+// newProof links every sequent of the solution to its premises
+func newProof(solution []*Sequent, sequents map[string]*Sequent) *Proof {
+	proof := &Proof{Steps: []*ProofStep{}}
+	steps := make(map[string]*ProofStep)
+
+	var add func(s *Sequent) *ProofStep
+	add = func(s *Sequent) *ProofStep {
+		if step, ok := steps[s.Name]; ok {
+			return step
+		}
+		step := &ProofStep{ID: s.Name, Sequent: s, Premises: []*ProofStep{}}
+		steps[s.Name] = step
+		if len(s.Justification) > 0 {
+			step.Rule = s.Justification[0]
+			for _, name := range s.Justification[1:] {
+				if parent, ok := sequents[name]; ok {
+					step.Premises = append(step.Premises, add(parent))
+				}
+			}
+		}
+		if s.principal != nil {
+			step.Principal = fmt.Sprintf("%s", s.principal)
+		}
+		if s.complement != nil {
+			step.Complement = fmt.Sprintf("%s", s.complement)
+		}
+		if s.unification != nil && len(s.unification.Map) > 0 {
+			step.Substitution = make(map[string]string)
+			for k, v := range s.unification.Map {
+				step.Substitution[k] = fmt.Sprintf("%s", v)
+			}
+		}
+		if s.unification != nil && len(s.unification.Paths) > 0 {
+			step.Worlds = make(map[string]string)
+			for k, v := range s.unification.Paths {
+				step.Worlds[k] = (&worldindex{Symbols: v}).String()
+			}
+		}
+		proof.Steps = append(proof.Steps, step)
+		return step
+	}
+
+	for _, s := range solution {
+		add(s)
+	}
+	if root, ok := sequents["S1"]; ok {
+		proof.Root = add(root)
+	}
+	if l := len(solution); l > 0 {
+		last := solution[l-1]
+		if len(last.Left) == 0 && len(last.Right) == 0 {
+			proof.Goal = steps[last.Name]
+		}
+	}
+	return proof
+}
+
+// Step returns the step with the given ID, nil if there is none
+func (p *Proof) Step(id string) *ProofStep {
+	for _, s := range p.Steps {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Walk visits every step the goal was derived from, starting from the goal and going back to the root
+// in breadth first order, each step is visited once. The walk stops when fn returns false
+func (p *Proof) Walk(fn func(*ProofStep) bool) {
+	if p.Goal == nil {
+		return
+	}
+	visited := map[*ProofStep]bool{p.Goal: true}
+	queue := []*ProofStep{p.Goal}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if !fn(s) {
+			return
+		}
+		for _, k := range s.Premises {
+			if !visited[k] {
+				visited[k] = true
+				queue = append(queue, k)
+			}
+		}
+	}
+}
+
+// PathToRoot returns the steps met going from s back to the root, always following the first premise
+func (p *Proof) PathToRoot(s *ProofStep) []*ProofStep {
+	out := []*ProofStep{}
+	visited := make(map[*ProofStep]bool)
+	for s != nil && !visited[s] {
+		visited[s] = true
+		out = append(out, s)
+		if len(s.Premises) == 0 {
+			break
+		}
+		s = s.Premises[0]
+	}
+	return out
+}
+
+// Derivation returns the steps the goal was derived from, premises before their conclusions
+func (p *Proof) Derivation() []*ProofStep {
+	used := make(map[*ProofStep]bool)
+	p.Walk(func(s *ProofStep) bool {
+		used[s] = true
+		return true
+	})
+	out := []*ProofStep{}
+	for _, s := range p.Steps {
+		if used[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (s *ProofStep) String() string {
+	return fmt.Sprintf("%s", s.Sequent)
+}
@@ -9,7 +9,7 @@ type (
 	}
 	resolutionRule interface {
 		getName() string
-		applyRuleTo(sequents *[]*Sequent) ([]*Sequent, error)
+		applyRuleTo(s1, s2 *Sequent) ([]*Sequent, error)
 	}
 	r1 struct {
 		Name string
@@ -47,6 +47,53 @@ type (
 		Name         string
 		worldsKeeper *worldskeeper
 	}
+	// native rules for the connectives kept by Prover.Native
+	r11 struct {
+		Name string
+	}
+	r12 struct {
+		Name string
+	}
+	r13 struct {
+		Name string
+	}
+	r14 struct {
+		Name string
+	}
+	r15 struct {
+		Name string
+	}
+	r16 struct {
+		Name string
+	}
+	r17 struct {
+		Name string
+	}
+	r18 struct {
+		Name string
+	}
+	r19 struct {
+		Name string
+	}
+	r20 struct {
+		Name string
+	}
+	r21 struct {
+		Name         string
+		worldsKeeper *worldskeeper
+	}
+	r22 struct {
+		Name         string
+		worldsKeeper *worldskeeper
+	}
+	r23 struct {
+		Name         string
+		worldsKeeper *worldskeeper
+	}
+	r24 struct {
+		Name         string
+		worldsKeeper *worldskeeper
+	}
 )
 
 // this functions rapresenting inference rules returns
@@ -56,46 +103,172 @@ type (
 
 // R1: If S,|p|_{i} <- T and S' <- |q|_{j}, T' and |p|_{i} and |q|_{j}
 // unify with unification O then S_{O} U S'_{O} <- T_{O} U T'_{O}
-func (r r1) applyRuleTo(sequents *[]*Sequent) ([]*Sequent, error) {
-	for _, s1 := range *sequents {
-		l1 := len(s1.Left)
-		if l1 < 1 {
+// it returns all the sequents obtained resolving an atom on the left of s1 with an atom on the right of s2,
+// the ones obtained by paramodulation from the equalities on the left of s1 into the atoms of s2
+// and, when s1 and s2 are the same sequent, the ones obtained removing the equalities s = t on the right
+// with s and t unifiable and the factors of s1
+func (r r1) applyRuleTo(s1, s2 *Sequent) ([]*Sequent, error) {
+	out := []*Sequent{}
+	for k1, f1 := range s1.Left {
+		if len(f1.Operands) != 0 { // Only atomic formulas can be resolved
 			continue
 		}
-		f1 := s1.Left[l1-1]
-		if len(f1.Operands) == 0 { // This means it is an atomic formula
-			for _, s2 := range *sequents {
-				l2 := len(s2.Right)
-				if l2 < 1 {
-					continue
-				}
-				f2 := s2.Right[0]
-				if len(f2.Operands) == 0 {
-					g := r.R.munify(f1, f2)
-					if g != nil {
-						n := &Sequent{}
+		for k2, f2 := range s2.Right {
+			if len(f2.Operands) != 0 {
+				continue
+			}
+			g := r.R.munify(f1, f2)
+			if g == nil {
+				continue
+			}
+			n := &Sequent{principal: f1, complement: f2, unification: g}
+
+			n.Left = merged(g.applyUnifications(s1.Left), k1)
+			n.Left = append(n.Left, g.applyUnifications(s2.Left)...)
+
+			n.Right = g.applyUnifications(s1.Right)
+			n.Right = append(n.Right, merged(g.applyUnifications(s2.Right), k2)...)
+
+			n.Justification = []string{r.Name, s1.Name, s2.Name}
+			if len(g.Map) > 0 {
+				n.Justification = append(n.Justification, fmt.Sprintf("%s", g))
+			}
 
-						t1 := g.applyUnifications(s1.Left[:l1-1])
-						t2 := g.applyUnifications(s2.Left)
-						n.Left = append(t1, t2...)
+			out = append(out, n)
+		}
+	}
+	out = append(out, r.paramodulate(s1, s2)...)
+	if s1 == s2 {
+		out = append(out, r.reflexivity(s1)...)
+		out = append(out, r.factor(s1)...)
+	}
+	return out, nil
+}
+
+// merged removes the k-th formula of fs together with its copies, so that a resolved atom
+// occurring more than once in a sequent is removed at once
+func merged(fs []*formula, k int) []*formula {
+	out := []*formula{}
+	for _, f := range fs {
+		if !sameFormula(f, fs[k]) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
 
-						t1 = g.applyUnifications(s1.Right)
-						t2 = g.applyUnifications(s2.Right[1:])
-						n.Right = append(t1, t2...)
+// paramodulate: If S,|l = r|_{i} <- T and S' <- T' where an atom |p|_{j} of S' or T' has a subterm u
+// such that l and u unify with unification O then S_{O} U S'_{O} <- T_{O} U T'_{O} with u replaced by r in p.
+// Terms are rigid designators, so that an equality holding in a world holds in every world,
+// the world index i must denote a world though, see anchor. Equalities are used in both directions
+func (r r1) paramodulate(s1, s2 *Sequent) []*Sequent {
+	out := []*Sequent{}
+	for k1, e := range s1.Left {
+		if e.Terminal != sEQUAL || len(e.Args) != 2 || len(e.Operands) != 0 {
+			continue
+		}
+		for _, left := range []bool{true, false} {
+			fs := s2.Right
+			if left {
+				fs = s2.Left
+			}
+			for k2, f := range fs {
+				if len(f.Operands) != 0 || s1 == s2 && left && k1 == k2 {
+					continue
+				}
+				w := r.R.anchor(&e.Index, &f.Index)
+				if w == nil {
+					continue
+				}
+				args := w.applyToTerms(f.Args)
+				for d := 0; d < 2; d++ {
+					from, to := w.applyToTerm(e.Args[d]), w.applyToTerm(e.Args[1-d])
+					subterms(args, nil, func(pos []int, u *term) {
+						m := unify([]*term{from}, []*term{u})
+						if m == nil || m.bindsWorlds(&e.Index, &f.Index) {
+							return
+						}
+						g := compose(m, w)
+						t := copyTopFormulaLevel(f)
+						t.Args = replaceTerm(args, pos, to)
+						rewritten := append(append(append([]*formula{}, fs[:k2]...), t), fs[k2+1:]...)
+
+						n := &Sequent{principal: e, complement: f, unification: g}
+						n.Left = append(g.applyUnifications(s1.Left[:k1]), g.applyUnifications(s1.Left[k1+1:])...)
+						n.Right = g.applyUnifications(s1.Right)
+						if left {
+							n.Left = append(n.Left, g.applyUnifications(rewritten)...)
+							n.Right = append(n.Right, g.applyUnifications(s2.Right)...)
+						} else {
+							n.Left = append(n.Left, g.applyUnifications(s2.Left)...)
+							n.Right = append(n.Right, g.applyUnifications(rewritten)...)
+						}
 
 						n.Justification = []string{r.Name, s1.Name, s2.Name}
 						if len(g.Map) > 0 {
 							n.Justification = append(n.Justification, fmt.Sprintf("%s", g))
 						}
+						out = append(out, n)
+					})
+				}
+			}
+		}
+	}
+	return out
+}
 
-						return []*Sequent{s1, s2, n}, nil
-					}
+// reflexivity: If S <- |s = t|_{i},T and s and t unify with unification O then S_{O} <- T_{O}
+func (r r1) reflexivity(s *Sequent) []*Sequent {
+	out := []*Sequent{}
+	for k, f := range s.Right {
+		if f.Terminal != sEQUAL || len(f.Args) != 2 || len(f.Operands) != 0 {
+			continue
+		}
+		g := unify(f.Args[:1], f.Args[1:])
+		if g == nil || g.bindsWorlds(&f.Index) {
+			continue
+		}
+		n := &Sequent{principal: f, unification: g}
+		n.Left = g.applyUnifications(s.Left)
+		n.Right = append(g.applyUnifications(s.Right[:k]), g.applyUnifications(s.Right[k+1:])...)
+
+		n.Justification = []string{r.Name, s.Name, s.Name}
+		if len(g.Map) > 0 {
+			n.Justification = append(n.Justification, fmt.Sprintf("%s", g))
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// factor: If S,|p|_{i},|q|_{j} <- T and |p|_{i} and |q|_{j} unify with unification O then S_{O},|p|_{i,O} <- T_{O},
+// the same holds for two atoms on the right. Without factors resolution would leave a copy of an atom
+// which only a substitution makes equal to the resolved one
+func (r r1) factor(s *Sequent) []*Sequent {
+	out := []*Sequent{}
+	for _, fs := range [][]*formula{s.Left, s.Right} {
+		for k1, f1 := range fs {
+			for _, f2 := range fs[k1+1:] {
+				if len(f1.Operands) != 0 || len(f2.Operands) != 0 {
+					continue
 				}
+				// An instance of the variables is enough, the worlds need not exist as they do for resolution
+				g := r.R.unifyAtoms(f1, f2, false)
+				if g == nil || len(g.Map) == 0 {
+					continue
+				}
+				n := &Sequent{principal: f1, complement: f2, unification: g}
+				n.Left = withoutCopies(g.applyUnifications(s.Left))
+				n.Right = withoutCopies(g.applyUnifications(s.Right))
+
+				n.Justification = []string{r.Name, s.Name, s.Name, fmt.Sprintf("%s", g)}
+				out = append(out, n)
 			}
 		}
 	}
-	return []*Sequent{}, nil
+	return out
 }
+
 func (r r1) getName() string {
 	return r.Name
 }
@@ -107,8 +280,8 @@ func (r r2) applyRuleTo(s *Sequent) (*Sequent, error) {
 		return nil, nil
 	}
 	f := s.Left[l-1]
-	if f.Terminal == sIMPLY {
-		n := &Sequent{}
+	if f.is(sIMPLY) {
+		n := &Sequent{principal: f}
 
 		t := copyTopFormulaLevel(f.Operands[1])
 		t.Index = f.Index
@@ -134,8 +307,8 @@ func (r r3) applyRuleTo(s *Sequent) (*Sequent, error) {
 		return nil, nil
 	}
 	f := s.Right[0]
-	if f.Terminal == sIMPLY {
-		n := &Sequent{}
+	if f.is(sIMPLY) {
+		n := &Sequent{principal: f}
 
 		t := copyTopFormulaLevel(f.Operands[1])
 		t.Index = f.Index
@@ -157,12 +330,12 @@ func (r r4) applyRuleTo(s *Sequent) (*Sequent, error) {
 		return nil, nil
 	}
 	f := s.Right[0]
-	if f.Terminal == sIMPLY {
-		n := &Sequent{}
+	if f.is(sIMPLY) {
+		n := &Sequent{principal: f}
 
 		t := copyTopFormulaLevel(f.Operands[0])
 		t.Index = f.Index
-		n.Left = append(s.Left, t)
+		n.Left = append(append([]*formula{}, s.Left...), t)
 		n.Right = s.Right[1:]
 
 		return n, nil
@@ -180,8 +353,8 @@ func (r r5) applyRuleTo(s *Sequent) (*Sequent, error) {
 		return nil, nil
 	}
 	f := s.Left[l-1]
-	if f.Terminal == sNOT {
-		n := &Sequent{}
+	if f.is(sNOT) {
+		n := &Sequent{principal: f}
 
 		t := copyTopFormulaLevel(f.Operands[0])
 		t.Index = f.Index
@@ -203,12 +376,12 @@ func (r r6) applyRuleTo(s *Sequent) (*Sequent, error) {
 		return nil, nil
 	}
 	f := s.Right[0]
-	if f.Terminal == sNOT {
-		n := &Sequent{}
+	if f.is(sNOT) {
+		n := &Sequent{principal: f}
 
 		t := copyTopFormulaLevel(f.Operands[0])
 		t.Index = f.Index
-		n.Left = append(s.Left, t)
+		n.Left = append(append([]*formula{}, s.Left...), t)
 		n.Right = s.Right[1:]
 
 		return n, nil
@@ -220,27 +393,17 @@ func (r r6) getName() string {
 }
 
 // R7: If S <- | Box p|_{i},T then S <- |p|_{n:i},T
+// n is reached by the agent of the Box
 func (r r7) applyRuleTo(s *Sequent) (*Sequent, error) {
 	l := len(s.Right)
 	if l < 1 {
 		return nil, nil
 	}
 	f := s.Right[0]
-	if f.Terminal == sBOX {
-		n := &Sequent{}
-
-		t := copyTopFormulaLevel(f.Operands[0])
-		t.Index = f.Index
-
-		if f.Index.isGround() && len(t.GetAllFreeVars(nil)) == 0 {
-			ns := r.worldsKeeper.GetFreeIndividualConstant()
-			t.Index.Symbols = append([]*worldsymbol{ns}, f.Index.Symbols...)
-		} else {
-			ns := r.worldsKeeper.GetSkolemFunctionOf(t)
-			t.Index.Symbols = append([]*worldsymbol{ns}, f.Index.Symbols...)
-		}
+	if f.is(sBOX) {
+		n := &Sequent{principal: f}
 		n.Left = s.Left
-		n.Right = append([]*formula{t}, s.Right[1:]...)
+		n.Right = append([]*formula{r.worldsKeeper.inNewWorld(f)}, s.Right[1:]...)
 
 		return n, nil
 	}
@@ -251,22 +414,17 @@ func (r r7) getName() string {
 }
 
 // R8: If S,|Box p|_{i} <- T then S,|p|_{w:i} <- T
+// w is reached by the agent of the Box
 func (r r8) applyRuleTo(s *Sequent) (*Sequent, error) {
 	l := len(s.Left)
 	if l < 1 {
 		return nil, nil
 	}
 	f := s.Left[l-1]
-	if f.Terminal == sBOX {
-		n := &Sequent{}
-
-		t := copyTopFormulaLevel(f.Operands[0])
-		ns := r.worldsKeeper.GetWorldVariable()
-		t.Index.Symbols = append([]*worldsymbol{ns}, f.Index.Symbols...)
-
-		// n.Left = append(s.Left[:l-1], t) TODO: WTF!!!!
+	if f.is(sBOX) {
+		n := &Sequent{principal: f}
 		n.Left = append([]*formula{}, s.Left[:l-1]...)
-		n.Left = append(n.Left, t)
+		n.Left = append(n.Left, r.worldsKeeper.inAnyWorld(f))
 		n.Right = s.Right
 
 		return n, nil
@@ -277,77 +435,365 @@ func (r r8) getName() string {
 	return r.Name
 }
 
+// R9: If S <- |forall x p|_{i},T then S <- |p[x/c]|_{i},T
+// c is a new constant or skolem function
 func (r r9) applyRuleTo(s *Sequent) (*Sequent, error) {
 	l := len(s.Right)
 	if l < 1 {
 		return nil, nil
 	}
 	f := s.Right[0]
-	if f.Terminal == sFORALL {
-		n := &Sequent{}
-
+	if f.is(sFORALL) {
+		n := &Sequent{principal: f}
 		n.Left = s.Left
+		n.Right = append([]*formula{r.worldsKeeper.withNewTerms(f)}, s.Right[1:]...)
 
-		t := copyTopFormulaLevel(f.Operands[len(f.Operands)-1])
-		t.Index = f.Index
+		return n, nil
+	}
+	return nil, nil
+}
+func (r r9) getName() string {
+	return r.Name
+}
 
-		g := &unification{Map: make(map[string]string)}
-		// TODO: Implement correct variable substitution
+// R10: If S,|forall x p|_{i} <- T then S,|p[x/v]|_{i} <- T
+// v is a new variable
+func (r r10) applyRuleTo(s *Sequent) (*Sequent, error) {
+	l := len(s.Left)
+	if l < 1 {
+		return nil, nil
+	}
+	f := s.Left[l-1]
+	if f.is(sFORALL) {
+		n := &Sequent{principal: f}
+		n.Left = append([]*formula{}, s.Left[:l-1]...)
+		n.Left = append(n.Left, r.worldsKeeper.withNewVariables(f))
+		n.Right = s.Right
 
-		m := make(map[string]bool)
-		for _, v := range f.Operands[:len(f.Operands)-1] {
-			m[v.Terminal] = true
-		}
+		return n, nil
+	}
+	return nil, nil
+}
+func (r r10) getName() string {
+	return r.Name
+}
 
-		if t.Index.isGround() && len(t.GetAllFreeVars(&m)) == 0 {
-			for _, v := range f.Vars {
-				g.Map[v] = (*r.worldsKeeper).GetFreeIndividualConstant().Value
-			}
-		} else {
-			for _, v := range f.Vars {
-				g.Map[v] = (*r.worldsKeeper).GetSkolemFunctionOf(t).Value
-			}
-		}
-		t = g.applyUnification(t)
+// R11: If S,|p and q|_{i} <- T then S,|p|_{i} <- T
+func (r r11) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceLeft(s, sAND, 0)
+}
+func (r r11) getName() string {
+	return r.Name
+}
 
-		n.Right = append([]*formula{t}, s.Right[1:]...)
+// R12: If S,|p and q|_{i} <- T then S,|q|_{i} <- T
+func (r r12) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceLeft(s, sAND, 1)
+}
+func (r r12) getName() string {
+	return r.Name
+}
 
-		return n, nil
+// R13: If S <- |p and q|_{i},T then S <- |p|_{i},|q|_{i},T
+func (r r13) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceRight(s, sAND, []int{}, []int{0, 1})
+}
+func (r r13) getName() string {
+	return r.Name
+}
+
+// R14: If S,|p or q|_{i} <- T then S,|p|_{i},|q|_{i} <- T
+func (r r14) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceLeft(s, sOR, 0, 1)
+}
+func (r r14) getName() string {
+	return r.Name
+}
+
+// R15: If S <- |p or q|_{i},T then S <- |p|_{i},T
+func (r r15) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceRight(s, sOR, []int{}, []int{0})
+}
+func (r r15) getName() string {
+	return r.Name
+}
+
+// R16: If S <- |p or q|_{i},T then S <- |q|_{i},T
+func (r r16) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceRight(s, sOR, []int{}, []int{1})
+}
+func (r r16) getName() string {
+	return r.Name
+}
+
+// R17: If S,|p iff q|_{i} <- T then S,|q|_{i} <- |p|_{i},T
+func (r r17) applyRuleTo(s *Sequent) (*Sequent, error) {
+	n, err := reduceLeft(s, sIFF, 1)
+	if n != nil {
+		n.Right = append([]*formula{operandOf(n.principal, 0)}, n.Right...)
 	}
-	return nil, nil
+	return n, err
 }
-func (r r9) getName() string {
+func (r r17) getName() string {
 	return r.Name
 }
 
-func (r r10) applyRuleTo(s *Sequent) (*Sequent, error) {
+// R18: If S,|p iff q|_{i} <- T then S,|p|_{i} <- |q|_{i},T
+func (r r18) applyRuleTo(s *Sequent) (*Sequent, error) {
+	n, err := reduceLeft(s, sIFF, 0)
+	if n != nil {
+		n.Right = append([]*formula{operandOf(n.principal, 1)}, n.Right...)
+	}
+	return n, err
+}
+func (r r18) getName() string {
+	return r.Name
+}
+
+// R19: If S <- |p iff q|_{i},T then S,|p|_{i},|q|_{i} <- T
+func (r r19) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceRight(s, sIFF, []int{0, 1}, []int{})
+}
+func (r r19) getName() string {
+	return r.Name
+}
+
+// R20: If S <- |p iff q|_{i},T then S <- |p|_{i},|q|_{i},T
+func (r r20) applyRuleTo(s *Sequent) (*Sequent, error) {
+	return reduceRight(s, sIFF, []int{}, []int{0, 1})
+}
+func (r r20) getName() string {
+	return r.Name
+}
+
+// R21: If S,|Diamond p|_{i} <- T then S,|p|_{n:i} <- T
+// n is reached by the agent of the Diamond
+func (r r21) applyRuleTo(s *Sequent) (*Sequent, error) {
 	l := len(s.Left)
 	if l < 1 {
 		return nil, nil
 	}
 	f := s.Left[l-1]
-	if f.Terminal == sFORALL {
-		n := &Sequent{}
+	if f.is(sDIAMOND) {
+		n := &Sequent{principal: f}
+		n.Left = append([]*formula{}, s.Left[:l-1]...)
+		n.Left = append(n.Left, r.worldsKeeper.inNewWorld(f))
+		n.Right = s.Right
 
-		t := copyTopFormulaLevel(f.Operands[len(f.Operands)-1])
-		t.Index = f.Index
-		g := &unification{Map: make(map[string]string)}
+		return n, nil
+	}
+	return nil, nil
+}
+func (r r21) getName() string {
+	return r.Name
+}
 
-		for _, v := range f.Operands[:len(f.Operands)-1] {
-			g.Map[v.Terminal] = (*r.worldsKeeper).GetWorldVariable().Value
-		}
+// R22: If S <- |Diamond p|_{i},T then S <- |p|_{w:i},T
+// w is reached by the agent of the Diamond
+func (r r22) applyRuleTo(s *Sequent) (*Sequent, error) {
+	l := len(s.Right)
+	if l < 1 {
+		return nil, nil
+	}
+	f := s.Right[0]
+	if f.is(sDIAMOND) {
+		n := &Sequent{principal: f}
+		n.Left = s.Left
+		n.Right = append([]*formula{r.worldsKeeper.inAnyWorld(f)}, s.Right[1:]...)
 
-		t = g.applyUnification(t)
+		return n, nil
+	}
+	return nil, nil
+}
+func (r r22) getName() string {
+	return r.Name
+}
 
-		// TODO: n.Left = append(n.Left[:l-1], t) this has side effects on s
+// R23: If S,|exists x p|_{i} <- T then S,|p[x/c]|_{i} <- T
+// c is a new constant or skolem function
+func (r r23) applyRuleTo(s *Sequent) (*Sequent, error) {
+	l := len(s.Left)
+	if l < 1 {
+		return nil, nil
+	}
+	f := s.Left[l-1]
+	if f.is(sEXISTS) {
+		n := &Sequent{principal: f}
 		n.Left = append([]*formula{}, s.Left[:l-1]...)
-		n.Left = append(n.Left, t)
+		n.Left = append(n.Left, r.worldsKeeper.withNewTerms(f))
 		n.Right = s.Right
 
 		return n, nil
 	}
 	return nil, nil
 }
-func (r r10) getName() string {
+func (r r23) getName() string {
+	return r.Name
+}
+
+// R24: If S <- |exists x p|_{i},T then S <- |p[x/v]|_{i},T
+// v is a new variable
+func (r r24) applyRuleTo(s *Sequent) (*Sequent, error) {
+	l := len(s.Right)
+	if l < 1 {
+		return nil, nil
+	}
+	f := s.Right[0]
+	if f.is(sEXISTS) {
+		n := &Sequent{principal: f}
+		n.Left = s.Left
+		n.Right = append([]*formula{r.worldsKeeper.withNewVariables(f)}, s.Right[1:]...)
+
+		return n, nil
+	}
+	return nil, nil
+}
+func (r r24) getName() string {
 	return r.Name
 }
+
+// operandOf returns the k-th operand of f in the world of f
+func operandOf(f *formula, k int) *formula {
+	t := copyTopFormulaLevel(f.Operands[k])
+	t.Index = f.Index
+	return t
+}
+
+// reduceLeft replaces the last formula on the left of s, if built by op, with the given operands
+func reduceLeft(s *Sequent, op string, operands ...int) (*Sequent, error) {
+	l := len(s.Left)
+	if l < 1 {
+		return nil, nil
+	}
+	f := s.Left[l-1]
+	if !f.is(op) {
+		return nil, nil
+	}
+	n := &Sequent{principal: f}
+	n.Left = append([]*formula{}, s.Left[:l-1]...)
+	for _, k := range operands {
+		n.Left = append(n.Left, operandOf(f, k))
+	}
+	n.Right = s.Right
+	return n, nil
+}
+
+// reduceRight removes the first formula on the right of s, if built by op,
+// and adds the given operands on the left and on the right
+func reduceRight(s *Sequent, op string, left, right []int) (*Sequent, error) {
+	l := len(s.Right)
+	if l < 1 {
+		return nil, nil
+	}
+	f := s.Right[0]
+	if !f.is(op) {
+		return nil, nil
+	}
+	n := &Sequent{principal: f}
+	n.Left = append([]*formula{}, s.Left...)
+	for _, k := range left {
+		n.Left = append(n.Left, operandOf(f, k))
+	}
+	n.Right = []*formula{}
+	for _, k := range right {
+		n.Right = append(n.Right, operandOf(f, k))
+	}
+	n.Right = append(n.Right, s.Right[1:]...)
+	return n, nil
+}
+
+// inNewWorld returns the operand of the modal formula f in a new world constant,
+// or skolem function of the world variables, reached by the agent of f.
+// The same formula is always given the same world, so that deriving it again does not name a new world
+func (k *worldskeeper) inNewWorld(f *formula) *formula {
+	t := copyTopFormulaLevel(f.Operands[0])
+	t.Index = f.Index
+	if k.worlds == nil {
+		k.worlds = make(map[string]*worldsymbol)
+	}
+	ns, ok := k.worlds[witnessKey(f)]
+	if !ok {
+		if f.Index.isGround() && len(t.GetAllFreeVars(nil)) == 0 {
+			ns = k.GetFreeIndividualConstant()
+			ns.Agent = f.Agent
+		} else {
+			sk := k.GetSkolemFunctionOf(t, nil)
+			ns = &worldsymbol{Value: sk.Value, Ground: true, Args: sk.Args, Agent: f.Agent}
+		}
+		k.worlds[witnessKey(f)] = ns
+	}
+	t.Index.Symbols = append([]*worldsymbol{ns}, f.Index.Symbols...)
+	return t
+}
+
+// witnessKey is the key of the worlds and terms given for f: its string with the names of its term
+// and world variables, so that a variable is not taken for a constant printed the same way
+func witnessKey(f *formula) string {
+	ws := []string{}
+	for _, s := range f.Index.Symbols {
+		if !s.Ground {
+			ws = append(ws, s.Value)
+		}
+	}
+	return fmt.Sprintf("%s/%v/%v", f, f.GetAllFreeVars(nil), ws)
+}
+
+// forget drops the worlds and terms given in a previous search
+func (k *worldskeeper) forget() {
+	k.worlds = nil
+	k.terms = nil
+}
+
+// inAnyWorld returns the operand of the modal formula f in a new world variable reached by the agent of f
+func (k *worldskeeper) inAnyWorld(f *formula) *formula {
+	t := copyTopFormulaLevel(f.Operands[0])
+	ns := k.GetWorldVariable()
+	ns.Agent = f.Agent
+	t.Index.Symbols = append([]*worldsymbol{ns}, f.Index.Symbols...)
+	return t
+}
+
+// withNewTerms returns the body of the quantified formula f with its variables replaced
+// by new constants, or skolem functions of the free variables, which are the same each time f is given
+func (k *worldskeeper) withNewTerms(f *formula) *formula {
+	t := copyTopFormulaLevel(f.Operands[len(f.Operands)-1])
+	t.Index = f.Index
+
+	g := &unification{Map: make(map[string]*term)}
+
+	m := make(map[string]bool)
+	for _, v := range f.Vars {
+		m[v] = true
+	}
+
+	if k.terms == nil {
+		k.terms = make(map[string][]*term)
+	}
+	ts, ok := k.terms[witnessKey(f)]
+	if !ok {
+		for range f.Vars {
+			if t.Index.isGround() && len(t.GetAllFreeVars(&m)) == 0 {
+				ts = append(ts, &term{Value: k.GetFreeIndividualConstant().Value})
+			} else {
+				ts = append(ts, k.GetSkolemFunctionOf(t, &m))
+			}
+		}
+		k.terms[witnessKey(f)] = ts
+	}
+	for i, v := range f.Vars {
+		g.Map[v] = ts[i]
+	}
+	return g.applyUnification(t)
+}
+
+// withNewVariables returns the body of the quantified formula f with its variables replaced by new variables
+func (k *worldskeeper) withNewVariables(f *formula) *formula {
+	t := copyTopFormulaLevel(f.Operands[len(f.Operands)-1])
+	t.Index = f.Index
+	g := &unification{Map: make(map[string]*term)}
+
+	for _, v := range f.Vars {
+		g.Map[v] = &term{Value: k.GetWorldVariable().Value, IsVar: true}
+	}
+	return g.applyUnification(t)
+}
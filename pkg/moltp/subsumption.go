@@ -0,0 +1,141 @@
+package moltp
+
+// merge removes the copies of the formulas of an interned sequent, each side keeps one copy of each formula
+func (s *Sequent) merge() {
+	s.Left = withoutCopies(s.Left)
+	s.Right = withoutCopies(s.Right)
+}
+
+func withoutCopies(fs []*formula) []*formula {
+	out := []*formula{}
+	for _, f := range fs {
+		copied := false
+		for _, g := range out {
+			if sameFormula(f, g) {
+				copied = true
+				break
+			}
+		}
+		if !copied {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// subsumedBy checks that one of the sequents in ss subsumes s
+func (s *Sequent) subsumedBy(ss ...[]*Sequent) bool {
+	for _, l := range ss {
+		for _, c := range l {
+			if subsumes(c, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subsumes checks that c, with its term and world variables replaced, has each of its formulas on the same side of d.
+// The replaced variables are bound to the string of their value, so d is implied by c and is not needed by the search
+func subsumes(c, d *Sequent) bool {
+	if len(c.Left) > len(d.Left) || len(c.Right) > len(d.Right) {
+		return false
+	}
+	fs := append(append([]*formula{}, c.Left...), c.Right...)
+	var match func(k int, m map[string]string) bool
+	match = func(k int, m map[string]string) bool {
+		if k == len(fs) {
+			return true
+		}
+		side := d.Right
+		if k < len(c.Left) {
+			side = d.Left
+		}
+		for _, g := range side {
+			n := make(map[string]string, len(m))
+			for v, t := range m {
+				n[v] = t
+			}
+			if matchLiteral(fs[k], g, make(map[string]bool), n) && match(k+1, n) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(0, make(map[string]string))
+}
+
+// matchLiteral checks that g is f with its free variables replaced as in m, bound holds the variables bound in f
+func matchLiteral(f, g *formula, bound map[string]bool, m map[string]string) bool {
+	if f.Terminal != g.Terminal || f.Agent != g.Agent || len(f.Operands) != len(g.Operands) || len(f.Args) != len(g.Args) {
+		return false
+	}
+	if len(f.Vars) != len(g.Vars) || len(f.Index.Symbols) != len(g.Index.Symbols) {
+		return false
+	}
+	for i, v := range f.Vars {
+		if v != g.Vars[i] {
+			return false
+		}
+	}
+	for i, a := range f.Index.Symbols {
+		b := g.Index.Symbols[i]
+		if a.Agent != b.Agent {
+			return false
+		}
+		if !a.Ground {
+			if !bind(a.Value, b.String(), m) {
+				return false
+			}
+		} else if a.Value != b.Value || !b.Ground || !matchTerms(a.Args, b.Args, bound, m) {
+			return false
+		}
+	}
+	if !matchTerms(f.Args, g.Args, bound, m) {
+		return false
+	}
+	inner := bound
+	if len(f.Vars) > 0 {
+		inner = make(map[string]bool)
+		for k, v := range bound {
+			inner[k] = v
+		}
+		for _, v := range f.Vars {
+			inner[v] = true
+		}
+	}
+	for i, o := range f.Operands {
+		if !matchLiteral(o, g.Operands[i], inner, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerms(as, bs []*term, bound map[string]bool, m map[string]string) bool {
+	if len(as) != len(bs) {
+		return false
+	}
+	for i, a := range as {
+		b := bs[i]
+		if a.IsVar && !bound[a.Value] && len(a.Args) == 0 {
+			if !bind(a.Value, b.String(), m) {
+				return false
+			}
+			continue
+		}
+		if a.Value != b.Value || a.IsVar != b.IsVar || !matchTerms(a.Args, b.Args, bound, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// bind binds the variable v to t unless it is bound to something else
+func bind(v, t string, m map[string]string) bool {
+	if k, ok := m[v]; ok {
+		return k == t
+	}
+	m[v] = t
+	return true
+}
@@ -0,0 +1,80 @@
+package moltp
+
+import (
+	"strings"
+	"unicode"
+)
+
+// plainOperator is an operator of the ASCII or Unicode syntax and the token it stands for
+type plainOperator struct {
+	symbol string
+	t      token
+}
+
+// asciiOperators are the operators of the ASCII syntax, longer symbols come first
+var asciiOperators = []plainOperator{
+	{"<->", token{IsOp: true, BiOp: true, Value: sIFF}},
+	{"<>", token{IsOp: true, UnOp: true, Value: sDIAMOND}},
+	{"[]", token{IsOp: true, UnOp: true, Value: sBOX}},
+	{"->", token{IsOp: true, BiOp: true, Value: sIMPLY}},
+	{"~", token{IsOp: true, UnOp: true, Value: sNOT}},
+	{"&", token{IsOp: true, BiOp: true, Value: sAND}},
+	{"|", token{IsOp: true, BiOp: true, Value: sOR}},
+}
+
+// unicodeOperators are the operators of the Unicode syntax
+var unicodeOperators = []plainOperator{
+	{"□", token{IsOp: true, UnOp: true, Value: sBOX}},
+	{"◇", token{IsOp: true, UnOp: true, Value: sDIAMOND}},
+	{"○", token{IsOp: true, UnOp: true, Value: sBOX, Agent: agentNext}},
+	{"→", token{IsOp: true, BiOp: true, Value: sIMPLY}},
+	{"↔", token{IsOp: true, BiOp: true, Value: sIFF}},
+	{"¬", token{IsOp: true, UnOp: true, Value: sNOT}},
+	{"∧", token{IsOp: true, BiOp: true, Value: sAND}},
+	{"∨", token{IsOp: true, BiOp: true, Value: sOR}},
+	{"∀", token{IsOp: true, MuOp: true, Value: sFORALL}},
+	{"∃", token{IsOp: true, MuOp: true, Value: sEXISTS}},
+}
+
+// asciiQuantifiers are the keywords of the quantifiers of the ASCII syntax
+var asciiQuantifiers = map[string]string{
+	"forall": sFORALL,
+	"exists": sEXISTS,
+}
+
+// detectSyntax guesses the syntax of s: TeX if it holds a macro, Unicode if it holds a non ASCII char, ASCII otherwise
+func detectSyntax(s string) string {
+	if strings.Contains(s, "\\") {
+		return SyntaxTeX
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		return SyntaxUnicode
+	}
+	return SyntaxASCII
+}
+
+// matchPlainOperator reads an operator of the ASCII or Unicode syntax at the beginning of s, nil if there is none.
+// TeX macros are read by both syntaxes, the dot after the variables of a quantifier, like in forall x. p(x), is skipped
+func matchPlainOperator(s, syntax string) *token {
+	operators := asciiOperators
+	if syntax == SyntaxUnicode {
+		operators = unicodeOperators
+	}
+	for _, o := range operators {
+		if strings.HasPrefix(s, o.symbol) {
+			t := o.t
+			t.Skip = len(o.symbol)
+			return &t
+		}
+	}
+	if s[0] == '.' {
+		return &token{Skip: 1}
+	}
+	if syntax == SyntaxASCII {
+		v := matchIdentifier(s)
+		if q, ok := asciiQuantifiers[v]; ok {
+			return &token{IsOp: true, MuOp: true, Value: q, Skip: len(v)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,490 @@
+package moltp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// kinds of the tokens of a TPTP file
+const (
+	tptpWord = iota // lower words, dollar words and numbers
+	tptpVariable
+	tptpQuoted
+	tptpSymbol
+)
+
+// tptpSymbols are the symbols of the TPTP syntax, longer symbols come first
+var tptpSymbols = []string{"<=>", "<~>", "==", "=>", "<=", "~|", "~&", "!=", "(", ")", "[", "]", ",", ":", ".", "~", "&", "|", "=", "!", "?"}
+
+// tptpConnectives holds the TeX notation of the binary connectives of the TPTP syntax
+var tptpConnectives = map[string]string{
+	"&":   "( %s \\land %s )",
+	"|":   "( %s \\lor %s )",
+	"=>":  "( %s \\to %s )",
+	"<=":  "( %[2]s \\to %[1]s )",
+	"<=>": "( %s \\iff %s )",
+	"<~>": "( \\lnot ( %s \\iff %s ) )",
+	"~|":  "( \\lnot ( %s \\lor %s ) )",
+	"~&":  "( \\lnot ( %s \\land %s ) )",
+}
+
+// tptpTrue is the formula $true, uppercase names are variables in TPTP so that it cannot be one of the atoms of the file
+const tptpTrue = "( True \\to True )"
+
+type tptpToken struct {
+	kind int
+	text string
+}
+
+// tptpReader reads the annotated formulas of a TPTP file, files are included from dir
+type tptpReader struct {
+	tokens []tptpToken
+	pos    int
+	dir    string
+}
+
+// ReadTPTP reads a problem in the TPTP syntax extended with the modal operators #box and #dia of QMLTP.
+// The fof and qmf formulas are translated to the TeX notation read by the prover, the conjecture
+// is the formula to be proved and the other formulas are premises holding in the same world. The logic specification,
+// like tff(s5, logic, $modal == [$modalities == $modal_system_S5]), gives the semantics of the problem.
+// Included files are read from dir
+func ReadTPTP(r io.Reader, dir string) (*Problem, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Problem{Premises: []*RawFormula{}}
+	err = readTPTP(p, string(data), dir)
+	if err != nil {
+		return nil, err
+	}
+	if p.Conjecture == nil {
+		return nil, fmt.Errorf("the problem has no conjecture")
+	}
+	return p, nil
+}
+
+// readTPTP adds the annotated formulas of s to p
+func readTPTP(p *Problem, s, dir string) error {
+	tokens, err := tptpTokenize(s)
+	if err != nil {
+		return err
+	}
+	t := &tptpReader{tokens: tokens, dir: dir}
+	for t.pos < len(t.tokens) {
+		err = t.annotated(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tptpTokenize(s string) ([]tptpToken, error) {
+	tokens := []tptpToken{}
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i = i + 1
+		case c == '%':
+			for i < len(s) && s[i] != '\n' {
+				i = i + 1
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			j := strings.Index(s[i+2:], "*/")
+			if j < 0 {
+				return nil, fmt.Errorf("missing end of comment")
+			}
+			i = i + j + 4
+		case c == '\'' || c == '"':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("missing closing %c", c)
+			}
+			tokens = append(tokens, tptpToken{kind: tptpQuoted, text: s[i+1 : i+1+j]})
+			i = i + j + 2
+		case c == '$' || c == '#' || isIdentifierChar(c):
+			j := i + 1
+			for j < len(s) && (isIdentifierChar(s[j]) || s[j] == '_') {
+				j = j + 1
+			}
+			kind := tptpWord
+			if c >= 'A' && c <= 'Z' {
+				kind = tptpVariable
+			}
+			tokens = append(tokens, tptpToken{kind: kind, text: s[i:j]})
+			i = j
+		default:
+			symbol := ""
+			for _, k := range tptpSymbols {
+				if strings.HasPrefix(s[i:], k) {
+					symbol = k
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("unexpected %c", c)
+			}
+			tokens = append(tokens, tptpToken{kind: tptpSymbol, text: symbol})
+			i = i + len(symbol)
+		}
+	}
+	return tokens, nil
+}
+
+func (t *tptpReader) peek() string {
+	if t.pos >= len(t.tokens) {
+		return ""
+	}
+	return t.tokens[t.pos].text
+}
+
+func (t *tptpReader) next() (tptpToken, error) {
+	if t.pos >= len(t.tokens) {
+		return tptpToken{}, fmt.Errorf("unexpected end of file")
+	}
+	t.pos = t.pos + 1
+	return t.tokens[t.pos-1], nil
+}
+
+func (t *tptpReader) expect(symbol string) error {
+	k, err := t.next()
+	if err != nil {
+		return err
+	}
+	if k.kind != tptpSymbol || k.text != symbol {
+		return fmt.Errorf("got %s want %s", k.text, symbol)
+	}
+	return nil
+}
+
+// skip reads the tokens up to the closing bracket of an open one, nested brackets included
+func (t *tptpReader) skip() ([]tptpToken, error) {
+	depth := 0
+	start := t.pos
+	for {
+		k, err := t.next()
+		if err != nil {
+			return nil, err
+		}
+		if k.kind != tptpSymbol {
+			continue
+		}
+		switch k.text {
+		case "(", "[":
+			depth = depth + 1
+		case ")", "]":
+			if depth == 0 {
+				t.pos = t.pos - 1
+				return t.tokens[start:t.pos], nil
+			}
+			depth = depth - 1
+		}
+	}
+}
+
+// annotated reads an annotated formula, like fof(name, role, formula), or an include
+func (t *tptpReader) annotated(p *Problem) error {
+	k, err := t.next()
+	if err != nil {
+		return err
+	}
+	err = t.expect("(")
+	if err != nil {
+		return err
+	}
+	if k.text == "include" {
+		name, err := t.next()
+		if err != nil {
+			return err
+		}
+		if name.kind != tptpQuoted {
+			return fmt.Errorf("include: got %s want a file name", name.text)
+		}
+		if t.peek() == "," {
+			// the names of the formulas to be included are ignored
+			_, err = t.skip()
+			if err != nil {
+				return err
+			}
+		}
+		err = t.end()
+		if err != nil {
+			return err
+		}
+		return t.include(p, name.text)
+	}
+
+	name, err := t.next()
+	if err != nil {
+		return err
+	}
+	err = t.expect(",")
+	if err != nil {
+		return err
+	}
+	role, err := t.next()
+	if err != nil {
+		return err
+	}
+	err = t.expect(",")
+	if err != nil {
+		return err
+	}
+	if role.text == "logic" {
+		spec, err := t.skip()
+		if err != nil {
+			return err
+		}
+		p.logic(spec)
+		return t.end()
+	}
+	if k.text != "fof" && k.text != "qmf" {
+		return fmt.Errorf("%s: %s formulas are not supported", name.text, k.text)
+	}
+	f, err := t.formula()
+	if err != nil {
+		return fmt.Errorf("%s: %s", name.text, err)
+	}
+	if t.peek() == "," {
+		// annotations
+		_, err = t.skip()
+		if err != nil {
+			return err
+		}
+	}
+	err = t.end()
+	if err != nil {
+		return err
+	}
+
+	switch role.text {
+	case "conjecture":
+		if p.Conjecture != nil {
+			return fmt.Errorf("%s: the problem has more than one conjecture", name.text)
+		}
+		p.Conjecture = &RawFormula{Formula: f}
+	case "axiom", "hypothesis", "definition", "assumption", "lemma", "theorem":
+		p.Premises = append(p.Premises, &RawFormula{OID: len(p.Premises) + 1, Formula: f})
+	default:
+		return fmt.Errorf("%s: role %s is not supported", name.text, role.text)
+	}
+	return nil
+}
+
+// end reads the end of an annotated formula
+func (t *tptpReader) end() error {
+	err := t.expect(")")
+	if err != nil {
+		return err
+	}
+	return t.expect(".")
+}
+
+func (t *tptpReader) include(p *Problem, name string) error {
+	data, err := ioutil.ReadFile(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+	err = readTPTP(p, string(data), t.dir)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+// logic reads the semantics of the problem from a logic specification, like
+// $modal == [$constants == $rigid, $quantification == $constant, $modalities == $modal_system_S5]
+func (p *Problem) logic(spec []tptpToken) {
+	for i := 0; i+2 < len(spec); i++ {
+		if spec[i+1].text != "==" {
+			continue
+		}
+		v := strings.TrimPrefix(spec[i+2].text, "$")
+		switch spec[i].text {
+		case "$modalities":
+			p.System = strings.TrimPrefix(v, "modal_system_")
+		case "$quantification":
+			p.Quantification = v
+		case "$constants":
+			p.Constants = v
+		}
+	}
+}
+
+// formula reads a formula and returns it in TeX notation with every compound formula enclosed in brackets
+func (t *tptpReader) formula() (string, error) {
+	a, err := t.unitary()
+	if err != nil {
+		return "", err
+	}
+	op := t.peek()
+	c, ok := tptpConnectives[op]
+	if !ok {
+		return a, nil
+	}
+	for t.peek() == op {
+		t.pos = t.pos + 1
+		b, err := t.unitary()
+		if err != nil {
+			return "", err
+		}
+		a = fmt.Sprintf(c, a, b)
+		if op != "&" && op != "|" {
+			// only & and | are associative
+			break
+		}
+	}
+	return a, nil
+}
+
+func (t *tptpReader) unitary() (string, error) {
+	k, err := t.next()
+	if err != nil {
+		return "", err
+	}
+	switch k.text {
+	case "(":
+		f, err := t.formula()
+		if err != nil {
+			return "", err
+		}
+		return f, t.expect(")")
+	case "~":
+		f, err := t.unitary()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("( \\lnot %s )", f), nil
+	case "!", "?":
+		q := "\\forall"
+		if k.text == "?" {
+			q = "\\exists"
+		}
+		err = t.expect("[")
+		if err != nil {
+			return "", err
+		}
+		vars := []string{}
+		for {
+			v, err := t.next()
+			if err != nil {
+				return "", err
+			}
+			if v.kind != tptpVariable {
+				return "", fmt.Errorf("got %s want a variable", v.text)
+			}
+			vars = append(vars, v.text)
+			if t.peek() != "," {
+				break
+			}
+			t.pos = t.pos + 1
+		}
+		err = t.expect("]")
+		if err != nil {
+			return "", err
+		}
+		err = t.expect(":")
+		if err != nil {
+			return "", err
+		}
+		f, err := t.unitary()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("( %s %s %s )", q, strings.Join(vars, ", "), f), nil
+	case "#box", "#dia":
+		op := "\\Box"
+		if k.text == "#dia" {
+			op = "\\Diamond"
+		}
+		if t.peek() == "(" {
+			// the agent of a multi modal operator, like #box(a)
+			t.pos = t.pos + 1
+			a, err := t.next()
+			if err != nil {
+				return "", err
+			}
+			op = fmt.Sprintf("%s_{%s}", op, a.text)
+			err = t.expect(")")
+			if err != nil {
+				return "", err
+			}
+		}
+		err = t.expect(":")
+		if err != nil {
+			return "", err
+		}
+		f, err := t.unitary()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("( %s %s )", op, f), nil
+	case "$true":
+		return tptpTrue, nil
+	case "$false":
+		return fmt.Sprintf("( \\lnot %s )", tptpTrue), nil
+	}
+	t.pos = t.pos - 1
+	l, err := t.term()
+	if err != nil {
+		return "", err
+	}
+	switch t.peek() {
+	case "=":
+		t.pos = t.pos + 1
+		r, err := t.term()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("( %s = %s )", l, r), nil
+	case "!=":
+		t.pos = t.pos + 1
+		r, err := t.term()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("( \\lnot ( %s = %s ) )", l, r), nil
+	}
+	return l, nil
+}
+
+// term reads an atom or a term, like f(X, g(a))
+func (t *tptpReader) term() (string, error) {
+	k, err := t.next()
+	if err != nil {
+		return "", err
+	}
+	if k.kind == tptpQuoted {
+		return "", fmt.Errorf("quoted name '%s' is not supported", k.text)
+	}
+	if k.kind != tptpWord && k.kind != tptpVariable || strings.HasPrefix(k.text, "$") || strings.HasPrefix(k.text, "#") {
+		return "", fmt.Errorf("unexpected %s", k.text)
+	}
+	if t.peek() != "(" {
+		return k.text, nil
+	}
+	t.pos = t.pos + 1
+	args := []string{}
+	for {
+		a, err := t.term()
+		if err != nil {
+			return "", err
+		}
+		args = append(args, a)
+		if t.peek() != "," {
+			break
+		}
+		t.pos = t.pos + 1
+	}
+	err = t.expect(")")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s(%s)", k.text, strings.Join(args, ",")), nil
+}